@@ -1,30 +1,90 @@
 package main
 
 import (
-	"crypto/sha256"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/cache"
+	"www.github.com/isaac-albert/httpfromtcp/internal/compression"
+	"www.github.com/isaac-albert/httpfromtcp/internal/fileserver"
+	"www.github.com/isaac-albert/httpfromtcp/internal/form"
+	"www.github.com/isaac-albert/httpfromtcp/internal/proxy"
 	"www.github.com/isaac-albert/httpfromtcp/internal/request"
 	"www.github.com/isaac-albert/httpfromtcp/internal/response"
 	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+	"www.github.com/isaac-albert/httpfromtcp/internal/sse"
+	"www.github.com/isaac-albert/httpfromtcp/internal/websocket"
 )
 
 const port = 42069
 
+var httpbinProxy server.Handler
+var forwardProxy *proxy.ForwardProxy
+var events = sse.NewHistory(100)
+var assets *fileserver.FileServer
+var routes *server.Mux
+
 func main() {
-	server, err := server.Serve(port, handle)
+	upstream := flag.String("upstream", "https://httpbin.org", "comma separated upstream urls for requests under /httpbin/")
+	strategy := flag.String("lb", "round-robin", "load balancing strategy: round-robin, least-connections or consistent-hash")
+	forwardPorts := flag.String("forward-ports", "", "comma separated ports CONNECT may tunnel to, enables the forward proxy when set")
+	cacheSize := flag.Int64("cache-size", 64<<20, "bytes of upstream responses under /httpbin/ kept in memory")
+	serverName := flag.String("server-name", "httpfromtcp", "product token sent in the Server header, empty to send none")
+	flag.Parse()
+
+	lbStrategy, err := proxy.ParseStrategy(*strategy)
+	if err != nil {
+		log.Fatalf("Error configuring proxy: %v", err)
+	}
+	lb, err := proxy.NewLoadBalancer(strings.Split(*upstream, ","), lbStrategy)
+	if err != nil {
+		log.Fatalf("Error configuring proxy: %v", err)
+	}
+	lb.Proxy.StripPrefix = "/httpbin"
+	lb.StartHealthChecks(10 * time.Second)
+	defer lb.Close()
+	httpbinProxy = cache.New(*cacheSize).Handler(lb.Handler)
+
+	if *forwardPorts != "" {
+		ports := []int{}
+		for _, p := range strings.Split(*forwardPorts, ",") {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				log.Fatalf("Error configuring forward proxy: invalid port '%s'", p)
+			}
+			ports = append(ports, port)
+		}
+		forwardProxy = proxy.NewForwardProxy(ports...)
+	}
+
+	//os.Root keeps symlinks inside ./assets from leading out of it
+	root, err := os.OpenRoot("./assets")
+	if err != nil {
+		log.Printf("Not serving assets: %v", err)
+	} else {
+		defer root.Close()
+		assets = fileserver.New(root.FS())
+		assets.StripPrefix = "/assets"
+		assets.Listing = true
+	}
+
+	routes = newRoutes()
+	go produceTicks()
+
+	server, err := server.Serve(port, compression.Handler(compression.Decompress(handle, 0), nil))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	defer server.Close()
+	server.SetName(*serverName)
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
@@ -34,26 +94,33 @@ func main() {
 }
 
 func handle(w *response.Writer, r *request.Request) {
-	if strings.HasPrefix(r.RequestLine.RequestTarget, "/httpbin/") {
-		ProxyHandler(w, r)
-		return
-	}
-	if r.RequestLine.RequestTarget == "/video" {
-		handleVideo(w, r)
-		return
-	}
-	if r.RequestLine.RequestTarget == "/yourproblem" {
-		handler400(w, r)
+	if forwardProxy != nil && proxy.IsProxyRequest(r) {
+		forwardProxy.Handler(w, r)
 		return
 	}
-	if r.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, r)
-		return
-	}
-	handler200(w, r)
+	routes.Handler(w, r)
 }
 
-
+// newRoutes registers the pages the server has. GET routes answer HEAD too,
+// and the proxy takes every method so that upstreams see them.
+func newRoutes() *server.Mux {
+	mux := server.NewMux()
+	mux.Handle("/httpbin/", httpbinProxy)
+	mux.Handle("/events", handleEvents, "GET")
+	mux.Handle("/ws", handleWebSocket, "GET")
+	mux.Handle("/video", handleVideo, "GET")
+	mux.Handle("/api/greet", server.HandleErrors(handleGreet), "POST")
+	mux.Handle("/upload", server.HandleErrors(handleUpload), "GET", "POST")
+	if assets != nil {
+		//the file server answers HEAD itself, without reading files
+		mux.Handle("/assets", assets.Handler, "GET", "HEAD")
+		mux.Handle("/assets/", assets.Handler, "GET", "HEAD")
+	}
+	mux.Handle("/yourproblem", handler400, "GET")
+	mux.Handle("/myproblem", handler500, "GET")
+	mux.Handle("/", handler200, "GET")
+	return mux
+}
 
 func handler400(w *response.Writer, _ *request.Request) {
 	msg := []byte(`
@@ -93,7 +160,7 @@ func handler500(w *response.Writer, _ *request.Request) {
 	w.WriteBody(msg)
 }
 
-func handler200(w *response.Writer, _ *request.Request) {
+func handler200(w *response.Writer, r *request.Request) {
 	msg := []byte(`
 		<html>
   <head>
@@ -105,95 +172,164 @@ func handler200(w *response.Writer, _ *request.Request) {
   </body>
 </html>
 		`)
+	validators := response.Validators{ETag: response.StrongETag(msg)}
+	if status := response.EvaluatePreconditions(r.RequestLine.Method, r.Headers, validators); status != response.StatusOK {
+		response.WritePrecondition(w, status, validators)
+		return
+	}
 	hdrs := response.GetDefaultHeaders(len(msg))
+	validators.SetHeaders(hdrs)
 	hdrs.ForceSet("Content-Type", "text/html")
 	w.WriteStatusLine(response.StatusOK)
 	w.WriteHeaders(hdrs)
 	w.WriteBody(msg)
 }
 
-func ProxyHandler(w *response.Writer, req *request.Request) {
-
-	body := make([]byte, 0)
-	lenOfBody := 0
-
-	streamURLStr := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
+func handleVideo(w *response.Writer, r *request.Request) {
+	if assets == nil {
+		handler500(w, r)
+		return
+	}
+	assets.ServeFile(w, r, "vim.mp4")
+}
 
-	url := fmt.Sprintf("https://httpbin.org/%s", streamURLStr)
-	//log.Printf("the url is: '%s'", url)
+const uploadPage = `<html>
+  <head>
+    <title>Upload</title>
+  </head>
+  <body>
+    <form method="post" enctype="multipart/form-data">
+      <input name="title">
+      <input type="file" name="file" multiple>
+      <button>Upload</button>
+    </form>
+  </body>
+</html>
+`
 
-	w.WriterState = response.StateWritingStatusLine
-	w.WriteStatusLine(response.StatusOK)
-	hdrs := response.GetDefaultHeaders(0)
-	hdrs.ForceRemoveHeader("Content-Length")
-	hdrs.ForceSet("Transfer-Coding", "chunked")
-	hdrs.ForceSet("Trailer", "X-Content-SHA256, X-Content-Length")
-	w.WriteHeaders(hdrs)
+// handleUpload shows an upload form and describes what was posted to it.
+func handleUpload(w *response.Writer, r *request.Request) error {
+	if r.RequestLine.Method == "GET" {
+		hdrs := response.GetDefaultHeaders(len(uploadPage))
+		hdrs.ForceSet("Content-Type", "text/html")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(hdrs)
+		w.WriteBody([]byte(uploadPage))
+		return nil
+	}
 
-	resp, err := http.Get(url)
+	f, err := form.Parse(r, nil)
 	if err != nil {
-		handler500(w, req)
-		return
+		var statusErr server.StatusError
+		if errors.As(err, &statusErr) {
+			return err
+		}
+		return &server.HandlerError{StatusCode: response.StatusBadRequest, Message: "malformed form", Err: err}
 	}
-	defer resp.Body.Close()
-
-	const bufferSize = 1024
-	buf := make([]byte, bufferSize)
+	defer f.RemoveAll()
 
-	for {
-		n, err := resp.Body.Read(buf)
-		fmt.Println("Read", n, "bytes")
-		if n > 0 {
-			n, err = w.WriteChunkedBody(buf[:n])
-			if err != nil {
-				fmt.Println("Error writing chunked body:", err)
-				break
-			}
-			//log.Printf("herre the error is: ")
-			body = append(body, buf[:n]...)
-			//log.Printf("if the point is here then error is not bodyappend")
-			lenOfBody += n
+	var sb strings.Builder
+	for name, values := range f.Values {
+		for _, value := range values {
+			fmt.Fprintf(&sb, "field %s: %q\n", name, value)
 		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			fmt.Println("Error reading response body:", err)
-			break
+	}
+	for name, files := range f.Files {
+		for _, fh := range files {
+			fmt.Fprintf(&sb, "file %s: %s, %d bytes\n", name, fh.Filename, fh.Size)
 		}
 	}
+	writeText(w, response.StatusOK, sb.String())
+	return nil
+}
+
+func writeText(w *response.Writer, status response.StatusCode, body string) {
+	w.WriteStatusLine(status)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+type greetRequest struct {
+	Name string `json:"name"`
+}
+
+type greetResponse struct {
+	Greeting string `json:"greeting"`
+}
 
-	_, err = w.WriteChunkedbodyDone()
+// handleGreet answers {"name": "..."} with a greeting, and bad requests with
+// problem details.
+func handleGreet(w *response.Writer, r *request.Request) error {
+	var body greetRequest
+	err := request.DecodeJSON(r, &body, 0)
 	if err != nil {
-		fmt.Println("error writing chunked body done")
+		return err
 	}
+	if strings.TrimSpace(body.Name) == "" {
+		return response.WriteProblem(w, response.Problem{
+			Status:     response.StatusUnprocessableContent,
+			Detail:     "name is required",
+			Extensions: map[string]any{"field": "name"},
+		})
+	}
+	return response.WriteJSON(w, response.StatusOK, greetResponse{Greeting: fmt.Sprintf("Hello, %s!", body.Name)})
+}
 
-	trailers := headers.NewHeaders()
-	sha256 := fmt.Sprintf("%x", sha256.Sum256(body))
-	trailers.ForceSet("X-Content-SHA256", sha256)
-	trailers.ForceSet("X-Content-Length", fmt.Sprintf("%d", len(body)))
-	err = w.WriteTrailers(trailers)
+// handleWebSocket echoes every message back to the client.
+func handleWebSocket(w *response.Writer, r *request.Request) {
+	conn, err := websocket.Upgrade(w, r, &websocket.UpgradeOptions{EnableCompression: true})
 	if err != nil {
-		fmt.Println("Error writing trailers:", err)
+		log.Printf("websocket upgrade failed: %v", err)
 		return
 	}
-	fmt.Println("Wrote trailers")
-}
+	defer conn.CloseNow()
 
-func handleVideo(w *response.Writer, _ *request.Request) {
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		err = conn.WriteMessage(msgType, data)
+		if err != nil {
+			return
+		}
+	}
+}
 
-	w.WriteStatusLine(response.StatusOK)
-	fileName := "./assets/vim.mp4"
+// produceTicks adds a tick to events every second, for all clients of
+// /events at once.
+func produceTicks() {
+	for now := range time.Tick(time.Second) {
+		events.Add(sse.Event{Event: "tick", Data: now.Format(time.RFC3339)})
+	}
+}
 
-	file, err := os.ReadFile(fileName)
+// handleEvents streams the ticks to the live dashboard, replaying the ticks a
+// reconnecting client missed first.
+func handleEvents(w *response.Writer, r *request.Request) {
+	stream, err := sse.NewWriter(w, r)
 	if err != nil {
-		handler500(w, nil)
 		return
 	}
+	defer stream.Close()
+	stream.StartHeartbeat(15 * time.Second)
 
-	
-	hdrs := response.GetDefaultHeaders(len(file))
-	hdrs.ForceSet("Content-Type", "video/mp4")
-	w.WriteHeaders(hdrs)
-	w.WriteBody(file)
-}
\ No newline at end of file
+	missed, live, cancel := events.Subscribe(stream.LastEventID())
+	defer cancel()
+	for _, e := range missed {
+		if stream.Send(e) != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case e, ok := <-live:
+			if !ok || stream.Send(e) != nil {
+				return
+			}
+		}
+	}
+}
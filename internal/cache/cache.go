@@ -0,0 +1,444 @@
+package cache
+
+import (
+	"container/list"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+)
+
+// StatusHeader tells clients how the cache handled their request: HIT,
+// MISS, EXPIRED (stale and refetched), REVALIDATED (stale, confirmed with a
+// conditional request) or BYPASS.
+const StatusHeader = "X-Cache"
+
+// cacheableStatus are the status codes stored when the response has explicit
+// freshness or a validator (RFC 9110 section 15.1).
+var cacheableStatus = map[response.StatusCode]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// Cache is a shared in-memory HTTP cache (RFC 9111) in front of a handler,
+// bounded to MaxBytes with least recently used eviction.
+type Cache struct {
+	MaxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	//vary holds the Vary header names last seen for each method and target,
+	//and variants how many entries are stored under it
+	vary     map[string][]string
+	variants map[string]int
+
+	now func() time.Time
+}
+
+type entry struct {
+	key     string
+	primary string
+
+	status  response.StatusCode
+	header  headers.Headers
+	body    []byte
+	noCache bool
+
+	storedAt   time.Time
+	initialAge time.Duration
+	lifetime   time.Duration
+}
+
+func (e *entry) size() int64 {
+	n := int64(len(e.key) + len(e.body))
+	for k, v := range e.header {
+		n += int64(len(k) + len(v))
+	}
+	return n
+}
+
+func (e *entry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.storedAt)
+}
+
+func (e *entry) validators() response.Validators {
+	v := response.Validators{}
+	v.ETag, _ = e.header.Get("etag")
+	if lastModified, ok := e.header.Get("last-modified"); ok {
+		v.LastModified, _ = response.ParseHTTPDate(lastModified)
+	}
+	return v
+}
+
+// New returns a Cache holding at most maxBytes of responses.
+func New(maxBytes int64) *Cache {
+	return &Cache{
+		MaxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		vary:     make(map[string][]string),
+		variants: make(map[string]int),
+		now:      time.Now,
+	}
+}
+
+// Handler serves GET and HEAD requests from the cache when it holds a fresh
+// response, revalidates stale ones with their ETag or Last-Modified, and
+// stores cacheable GET responses from next. Responses are streamed to the
+// client while being recorded, so uncacheable streams still work.
+func (c *Cache) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		method := req.RequestLine.Method
+		target := req.RequestLine.RequestTarget
+
+		//a wrapped Writer can't be hijacked
+		if _, ok := req.Headers.Get("upgrade"); ok || method == "CONNECT" {
+			next(w, req)
+			return
+		}
+
+		if method != "GET" && method != "HEAD" {
+			rec := &recorder{PassThrough: response.PassThrough{W: w}, status: "BYPASS"}
+			next(w.Wrap(rec), req)
+			//a successful unsafe method invalidates what we hold for the
+			//target (RFC 9111 section 4.4)
+			if rec.statusCode < 400 {
+				c.invalidate("GET " + target)
+			}
+			return
+		}
+
+		reqDirectives := parseCacheControl(req.Headers)
+		_, authorized := req.Headers.Get("authorization")
+		if _, noStore := reqDirectives["no-store"]; noStore || authorized {
+			next(w.Wrap(&recorder{PassThrough: response.PassThrough{W: w}, status: "BYPASS"}), req)
+			return
+		}
+
+		//HEAD is answered from the stored GET response
+		primary := "GET " + target
+		e := c.lookup(primary, req.Headers)
+		if e != nil && c.fresh(e, reqDirectives) {
+			c.serve(w, method, req.Headers, e, "HIT")
+			return
+		}
+
+		reqHeaders := cloneHeaders(req.Headers)
+		rec := &recorder{
+			PassThrough: response.PassThrough{W: w},
+			status:      "MISS",
+			store:       method == "GET",
+			limit:       c.MaxBytes,
+			now:         c.now,
+		}
+		if e != nil {
+			rec.status = "EXPIRED"
+			//ask next whether what we hold is still good, in place of the
+			//client's own conditions which are evaluated against the entry
+			v := e.validators()
+			if v.ETag != "" || !v.LastModified.IsZero() {
+				rec.revalidating = true
+				for _, name := range []string{"if-match", "if-none-match", "if-modified-since", "if-unmodified-since", "if-range"} {
+					req.Headers.ForceRemoveHeader(name)
+				}
+				if v.ETag != "" {
+					req.Headers.ForceSet("If-None-Match", v.ETag)
+				}
+				if !v.LastModified.IsZero() {
+					req.Headers.ForceSet("If-Modified-Since", response.FormatHTTPDate(v.LastModified))
+				}
+			}
+		}
+		next(w.Wrap(rec), req)
+
+		if rec.swallowed {
+			c.serve(w, method, reqHeaders, c.refresh(e, rec.header), "REVALIDATED")
+			return
+		}
+		if rec.complete && rec.record && !rec.tooLarge {
+			c.store(primary, reqHeaders, rec)
+		}
+	}
+}
+
+// lookup finds the entry matching the request's values of the headers the
+// stored response varies on.
+func (c *Cache) lookup(primary string, reqHeaders headers.Headers) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names, ok := c.vary[primary]
+	if !ok {
+		return nil
+	}
+	elem, ok := c.entries[variantKey(primary, names, reqHeaders)]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*entry)
+}
+
+func (c *Cache) fresh(e *entry, reqDirectives map[string]string) bool {
+	if e.noCache {
+		return false
+	}
+	if _, ok := reqDirectives["no-cache"]; ok {
+		return false
+	}
+	age := e.age(c.now())
+	if maxAge, ok := reqDirectives["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		if err == nil && age > time.Duration(seconds)*time.Second {
+			return false
+		}
+	}
+	return age < e.lifetime
+}
+
+func (c *Cache) serve(w *response.Writer, method string, reqHeaders headers.Headers, e *entry, status string) {
+	statusCode, body := e.status, e.body
+	h := cloneHeaders(e.header)
+	validators := e.validators()
+	age := e.age(c.now())
+
+	if statusCode == response.StatusOK {
+		code := response.EvaluatePreconditions(method, reqHeaders, validators)
+		if code != response.StatusOK {
+			response.WritePrecondition(w, code, validators)
+			return
+		}
+	}
+
+	h.ForceRemoveHeader("transfer-encoding")
+	h.ForceRemoveHeader("trailer")
+	h.ForceSet("Content-Length", strconv.Itoa(len(body)))
+	h.ForceSet("Age", strconv.Itoa(int(age.Seconds())))
+	h.ForceSet(StatusHeader, status)
+
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	if method == "HEAD" || !response.BodyAllowed(statusCode) {
+		return
+	}
+	w.WriteBody(body)
+}
+
+// refresh returns a copy of a stale entry updated with the headers of the
+// 304 that confirmed it (RFC 9111 section 4.3.4), and puts it in place of the
+// old one. Entries are never changed once stored, so they can be served
+// without holding the lock.
+func (c *Cache) refresh(e *entry, h headers.Headers) *entry {
+	now := c.now()
+	updated := *e
+	updated.header = cloneHeaders(e.header)
+	for _, name := range []string{"cache-control", "expires", "date", "etag", "last-modified", "vary"} {
+		if v, ok := h.Get(name); ok {
+			updated.header.ForceSet(name, v)
+		}
+	}
+	updated.lifetime, updated.noCache, _ = freshness(updated.status, updated.header, now)
+	updated.storedAt = now
+	updated.initialAge = 0
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[e.key]; ok && elem.Value == e {
+		c.size += updated.size() - e.size()
+		elem.Value = &updated
+		c.evict()
+	}
+	return &updated
+}
+
+func (c *Cache) store(primary string, reqHeaders headers.Headers, rec *recorder) {
+	now := c.now()
+	lifetime, noCache, ok := freshness(rec.statusCode, rec.header, now)
+	if !ok {
+		return
+	}
+	names := varyNames(rec.header)
+
+	e := &entry{
+		primary:  primary,
+		status:   rec.statusCode,
+		header:   rec.header,
+		body:     rec.body,
+		noCache:  noCache,
+		storedAt: now,
+		lifetime: lifetime,
+	}
+	e.header.ForceRemoveHeader(StatusHeader)
+	if age, err := strconv.Atoi(e.header["age"]); err == nil && age > 0 {
+		e.initialAge = time.Duration(age) * time.Second
+	}
+	e.header.ForceRemoveHeader("age")
+	e.key = variantKey(primary, names, reqHeaders)
+	if e.size() > c.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[e.key]; ok {
+		c.remove(elem)
+	}
+	c.vary[primary] = names
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.variants[primary]++
+	c.size += e.size()
+	c.evict()
+}
+
+// evict drops least recently used entries until the cache fits MaxBytes.
+func (c *Cache) evict() {
+	for c.size > c.MaxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) invalidate(primaries ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		e := elem.Value.(*entry)
+		for _, primary := range primaries {
+			if e.primary == primary {
+				c.remove(elem)
+				break
+			}
+		}
+		elem = next
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := elem.Value.(*entry)
+	c.lru.Remove(elem)
+	delete(c.entries, e.key)
+	c.size -= e.size()
+	c.variants[e.primary]--
+	if c.variants[e.primary] == 0 {
+		delete(c.variants, e.primary)
+		delete(c.vary, e.primary)
+	}
+}
+
+// freshness works out how long a response stays fresh in a shared cache, and
+// whether it may be stored at all (RFC 9111 sections 3 and 4.2.1). Responses
+// without explicit freshness are only kept when they can be revalidated.
+func freshness(status response.StatusCode, h headers.Headers, now time.Time) (time.Duration, bool, bool) {
+	if !cacheableStatus[status] {
+		return 0, false, false
+	}
+	directives := parseCacheControl(h)
+	for _, d := range []string{"no-store", "private"} {
+		if _, ok := directives[d]; ok {
+			return 0, false, false
+		}
+	}
+	if _, ok := h.Get("set-cookie"); ok {
+		return 0, false, false
+	}
+	for _, name := range varyNames(h) {
+		if name == "*" {
+			return 0, false, false
+		}
+	}
+	_, noCache := directives["no-cache"]
+
+	var lifetime time.Duration
+	explicit := false
+	if v, ok := directives["s-maxage"]; ok {
+		lifetime, explicit = parseSeconds(v)
+	}
+	if v, ok := directives["max-age"]; ok && !explicit {
+		lifetime, explicit = parseSeconds(v)
+	}
+	if v, ok := h.Get("expires"); ok && !explicit {
+		explicit = true
+		expires, err := response.ParseHTTPDate(v)
+		if err == nil {
+			date := now
+			if d, ok := h.Get("date"); ok {
+				if parsed, err := response.ParseHTTPDate(d); err == nil {
+					date = parsed
+				}
+			}
+			lifetime = max(expires.Sub(date), 0)
+		}
+	}
+
+	_, hasETag := h.Get("etag")
+	_, hasLastModified := h.Get("last-modified")
+	if lifetime <= 0 && !hasETag && !hasLastModified {
+		return 0, false, false
+	}
+	return lifetime, noCache, true
+}
+
+func parseSeconds(v string) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// parseCacheControl returns the Cache-Control directives with lowercase
+// names and unquoted values.
+func parseCacheControl(h headers.Headers) map[string]string {
+	directives := map[string]string{}
+	value, _ := h.Get("cache-control")
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+	}
+	return directives
+}
+
+func varyNames(h headers.Headers) []string {
+	value, _ := h.Get("vary")
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func variantKey(primary string, names []string, reqHeaders headers.Headers) string {
+	var sb strings.Builder
+	sb.WriteString(primary)
+	for _, name := range names {
+		value, _ := reqHeaders.Get(name)
+		sb.WriteString("\x00" + name + "=" + value)
+	}
+	return sb.String()
+}
+
+func cloneHeaders(h headers.Headers) headers.Headers {
+	clone := headers.NewHeaders()
+	for k, v := range h {
+		clone[k] = v
+	}
+	return clone
+}
@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+// clock is a settable time source for the cache.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// origin answers every request from its routes and counts how often it was
+// asked for each target.
+type origin struct {
+	mu     sync.Mutex
+	calls  map[string]int
+	routes map[string]func(w *response.Writer, req *request.Request)
+}
+
+func (o *origin) handle(w *response.Writer, req *request.Request) {
+	o.mu.Lock()
+	o.calls[req.RequestLine.RequestTarget]++
+	route := o.routes[req.RequestLine.RequestTarget]
+	o.mu.Unlock()
+	route(w, req)
+}
+
+func (o *origin) count(target string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.calls[target]
+}
+
+func reply(status response.StatusCode, body string, kv ...string) func(*response.Writer, *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		for i := 0; i+1 < len(kv); i += 2 {
+			h.ForceSet(kv[i], kv[i+1])
+		}
+		w.WriteStatusLine(status)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func startCache(t *testing.T, maxBytes int64, routes map[string]func(*response.Writer, *request.Request)) (*origin, *clock, string) {
+	t.Helper()
+	o := &origin{calls: map[string]int{}, routes: routes}
+	clk := &clock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	c := New(maxBytes)
+	c.now = clk.Now
+
+	return o, clk, servertest.Start(t, c.Handler(o.handle))
+}
+
+func TestFreshAndStale(t *testing.T) {
+	etag := `"v1"`
+	o, clk, base := startCache(t, 1<<20, map[string]func(*response.Writer, *request.Request){
+		"/page": func(w *response.Writer, req *request.Request) {
+			if inm, _ := req.Headers.Get("if-none-match"); inm == etag {
+				h := headers.NewHeaders()
+				h.Set("ETag", etag)
+				h.Set("Cache-Control", "max-age=120")
+				w.WriteStatusLine(response.StatusNotModified)
+				w.WriteHeaders(h)
+				return
+			}
+			reply(200, "page body", "Cache-Control", "max-age=60", "ETag", etag)(w, req)
+		},
+	})
+
+	// Test: The first request goes to the origin, the next is answered from
+	// the cache with its age
+	resp, body := servertest.Do(t, nil, "GET", base+"/page")
+	assert.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+	assert.Equal(t, "page body", body)
+	clk.Advance(10 * time.Second)
+	resp, body = servertest.Do(t, nil, "GET", base+"/page")
+	assert.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	assert.Equal(t, "10", resp.Header.Get("Age"))
+	assert.Equal(t, "page body", body)
+	assert.Equal(t, 1, o.count("/page"))
+
+	// Test: HEAD is answered from the stored GET response
+	resp, _ = servertest.Do(t, nil, "HEAD", base+"/page")
+	assert.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	assert.Equal(t, int64(9), resp.ContentLength)
+	assert.Equal(t, 1, o.count("/page"))
+
+	// Test: Client conditionals are evaluated against the entry
+	resp, _ = servertest.Do(t, nil, "GET", base+"/page", "If-None-Match", etag)
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, 1, o.count("/page"))
+
+	// Test: Once stale, the entry is revalidated with its ETag and the 304
+	// extends its lifetime
+	clk.Advance(60 * time.Second)
+	resp, body = servertest.Do(t, nil, "GET", base+"/page")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "REVALIDATED", resp.Header.Get(StatusHeader))
+	assert.Equal(t, "page body", body)
+	assert.Equal(t, 2, o.count("/page"))
+	clk.Advance(90 * time.Second)
+	resp, _ = servertest.Do(t, nil, "GET", base+"/page")
+	assert.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	assert.Equal(t, 2, o.count("/page"))
+
+	// Test: Request directives can ask for a fresher response
+	resp, _ = servertest.Do(t, nil, "GET", base+"/page", "Cache-Control", "max-age=30")
+	assert.Equal(t, "REVALIDATED", resp.Header.Get(StatusHeader))
+	resp, _ = servertest.Do(t, nil, "GET", base+"/page", "Cache-Control", "no-store")
+	assert.Equal(t, "BYPASS", resp.Header.Get(StatusHeader))
+	assert.Equal(t, 4, o.count("/page"))
+}
+
+func TestStorability(t *testing.T) {
+	o, clk, base := startCache(t, 1<<20, map[string]func(*response.Writer, *request.Request){
+		"/no-store": reply(200, "x", "Cache-Control", "no-store, max-age=60"),
+		"/private":  reply(200, "x", "Cache-Control", "private, max-age=60"),
+		"/cookie":   reply(200, "x", "Cache-Control", "max-age=60", "Set-Cookie", "a=b"),
+		"/none":     reply(200, "x"),
+		"/error":    reply(500, "x", "Cache-Control", "max-age=60"),
+		"/shared":   reply(200, "x", "Cache-Control", "max-age=0, s-maxage=60"),
+		"/expires":  reply(200, "x", "Date", "Wed, 01 May 2024 12:00:00 GMT", "Expires", "Wed, 01 May 2024 12:00:30 GMT"),
+		"/no-cache": reply(200, "x", "Cache-Control", "no-cache", "Last-Modified", "Wed, 01 May 2024 11:00:00 GMT"),
+	})
+
+	// Test: Responses a shared cache must not keep go to the origin every time
+	for _, target := range []string{"/no-store", "/private", "/cookie", "/none", "/error"} {
+		servertest.Do(t, nil, "GET", base+target)
+		resp, _ := servertest.Do(t, nil, "GET", base+target)
+		assert.Equal(t, "MISS", resp.Header.Get(StatusHeader), target)
+		assert.Equal(t, 2, o.count(target), target)
+	}
+
+	// Test: s-maxage wins over max-age, Expires counts from Date
+	for _, target := range []string{"/shared", "/expires"} {
+		servertest.Do(t, nil, "GET", base+target)
+		resp, _ := servertest.Do(t, nil, "GET", base+target)
+		assert.Equal(t, "HIT", resp.Header.Get(StatusHeader), target)
+	}
+	clk.Advance(45 * time.Second)
+	resp, _ := servertest.Do(t, nil, "GET", base+"/expires")
+	assert.Equal(t, "EXPIRED", resp.Header.Get(StatusHeader))
+
+	// Test: no-cache responses are stored but always revalidated; this
+	// origin ignores the condition, so the new response replaces the entry
+	servertest.Do(t, nil, "GET", base+"/no-cache")
+	resp, _ = servertest.Do(t, nil, "GET", base+"/no-cache")
+	assert.Equal(t, "EXPIRED", resp.Header.Get(StatusHeader))
+	assert.Equal(t, 2, o.count("/no-cache"))
+}
+
+func TestVaryAndInvalidation(t *testing.T) {
+	o, _, base := startCache(t, 1<<20, map[string]func(*response.Writer, *request.Request){
+		"/greeting": func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.Method == "POST" {
+				reply(204, "")(w, req)
+				return
+			}
+			lang, _ := req.Headers.Get("accept-language")
+			reply(200, "hello "+lang, "Cache-Control", "max-age=60", "Vary", "Accept-Language")(w, req)
+		},
+	})
+
+	// Test: Each value of a Vary header gets its own entry
+	_, body := servertest.Do(t, nil, "GET", base+"/greeting", "Accept-Language", "en")
+	assert.Equal(t, "hello en", body)
+	_, body = servertest.Do(t, nil, "GET", base+"/greeting", "Accept-Language", "fr")
+	assert.Equal(t, "hello fr", body)
+	resp, body := servertest.Do(t, nil, "GET", base+"/greeting", "Accept-Language", "en")
+	assert.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	assert.Equal(t, "hello en", body)
+	assert.Equal(t, 2, o.count("/greeting"))
+
+	// Test: Requests with credentials are not served from the cache
+	resp, _ = servertest.Do(t, nil, "GET", base+"/greeting", "Accept-Language", "en", "Authorization", "Bearer x")
+	assert.Equal(t, "BYPASS", resp.Header.Get(StatusHeader))
+
+	// Test: A successful POST invalidates every variant
+	resp, _ = servertest.Do(t, nil, "POST", base+"/greeting")
+	assert.Equal(t, 204, resp.StatusCode)
+	resp, _ = servertest.Do(t, nil, "GET", base+"/greeting", "Accept-Language", "fr")
+	assert.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+}
+
+func TestEviction(t *testing.T) {
+	body := strings.Repeat("x", 400)
+	routes := map[string]func(*response.Writer, *request.Request){}
+	for _, target := range []string{"/a", "/b", "/c", "/big"} {
+		routes[target] = reply(200, body, "Cache-Control", "max-age=60")
+	}
+	routes["/big"] = reply(200, strings.Repeat("x", 2000), "Cache-Control", "max-age=60")
+	_, _, base := startCache(t, 1200, routes)
+
+	// Test: The least recently used entry goes first
+	servertest.Do(t, nil, "GET", base+"/a")
+	servertest.Do(t, nil, "GET", base+"/b")
+	resp, _ := servertest.Do(t, nil, "GET", base+"/a")
+	assert.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	servertest.Do(t, nil, "GET", base+"/c")
+	resp, _ = servertest.Do(t, nil, "GET", base+"/a")
+	assert.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	resp, _ = servertest.Do(t, nil, "GET", base+"/b")
+	assert.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+
+	// Test: Responses larger than the cache are passed through whole
+	servertest.Do(t, nil, "GET", base+"/big")
+	resp, got := servertest.Do(t, nil, "GET", base+"/big")
+	assert.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+	assert.Len(t, got, 2000)
+}
+
+func TestChunkedResponse(t *testing.T) {
+	_, _, base := startCache(t, 1<<20, map[string]func(*response.Writer, *request.Request){
+		"/stream": func(w *response.Writer, req *request.Request) {
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			h.Set("Cache-Control", "max-age=60")
+			h.Set("Connection", "close")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("one "))
+			w.WriteChunkedBody([]byte("two"))
+			w.WriteChunkedbodyDone()
+			w.WriteTrailers(headers.NewHeaders())
+		},
+	})
+
+	// Test: A streamed response is passed on as chunks and replayed with a
+	// length
+	resp, body := servertest.Do(t, nil, "GET", base+"/stream")
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "one two", body)
+	resp, body = servertest.Do(t, nil, "GET", base+"/stream")
+	assert.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	assert.Equal(t, int64(7), resp.ContentLength)
+	assert.Equal(t, "one two", body)
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"io"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// recorder is the Transport handlers write to behind the cache. It passes the
+// response on to the client as it comes and keeps a copy of storable ones.
+type recorder struct {
+	response.PassThrough
+	status string
+
+	//store is set for GET requests; the copy is dropped past limit bytes
+	store bool
+	limit int64
+	now   func() time.Time
+	//revalidating means a 304 confirms the stale entry and is not forwarded
+	revalidating bool
+
+	statusCode response.StatusCode
+	header     headers.Headers
+	record     bool
+	body       []byte
+	tooLarge   bool
+	complete   bool
+	swallowed  bool
+}
+
+func (r *recorder) WriteHeader(statusCode response.StatusCode, h headers.Headers) error {
+	r.statusCode = statusCode
+	r.header = cloneHeaders(h)
+
+	if r.revalidating && statusCode == response.StatusNotModified {
+		//never passed on, so the rest of the response is dropped too
+		r.swallowed = true
+		return nil
+	}
+	if r.store {
+		_, _, r.record = freshness(statusCode, h, r.now())
+	}
+	if !response.BodyAllowed(statusCode) {
+		r.complete = true
+	}
+
+	h.ForceSet(StatusHeader, r.status)
+	return r.PassThrough.WriteHeader(statusCode, h)
+}
+
+func (r *recorder) WriteBody(p []byte) (int, error) {
+	r.Write(p)
+	return r.PassThrough.WriteBody(p)
+}
+
+func (r *recorder) WriteBodyFrom(src io.Reader) (int64, error) {
+	if r.record {
+		src = io.TeeReader(src, r)
+	}
+	return r.PassThrough.WriteBodyFrom(src)
+}
+
+func (r *recorder) Finish(trailers headers.Headers) error {
+	if !r.swallowed {
+		r.complete = true
+	}
+	return r.PassThrough.Finish(trailers)
+}
+
+// Write records body bytes for the cache.
+func (r *recorder) Write(p []byte) (int, error) {
+	if !r.record || r.tooLarge {
+		return len(p), nil
+	}
+	if int64(len(r.body)+len(p)) > r.limit {
+		r.tooLarge = true
+		r.body = nil
+		return len(p), nil
+	}
+	r.body = append(r.body, p...)
+	return len(p), nil
+}
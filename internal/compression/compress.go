@@ -0,0 +1,309 @@
+package compression
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+)
+
+// DefaultContentTypes are the media types worth compressing, matched as
+// prefixes of the response Content-Type.
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+// compressedTypes are never compressed, even when an allowlist entry matches
+// them: most are compressed already, and event streams have to reach the
+// client event by event, where an encoder holds on to what it is given.
+var compressedTypes = []string{
+	"video/",
+	"audio/",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"text/event-stream",
+}
+
+// supported lists the codings we can produce, in order of preference for
+// equal q-values. Nothing else is negotiated, br included.
+var supported = []string{"gzip", "deflate"}
+
+type Options struct {
+	// MinSize is the smallest body worth compressing, 1024 when zero.
+	MinSize int
+	// ContentTypes is the allowlist of media type prefixes, DefaultContentTypes
+	// when empty.
+	ContentTypes []string
+	// Level is the gzip/zlib compression level, the default level when zero.
+	Level int
+}
+
+// Handler compresses the responses of next with the best coding the client
+// accepts. Compressed bodies are streamed through the encoder and sent
+// chunked, without a Content-Length, so nothing is held in memory; chunked
+// responses are flushed after every chunk. HEAD gets the headers GET would.
+func Handler(next server.Handler, opts *Options) server.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return func(w *response.Writer, req *request.Request) {
+		//the writer we hand out can't be hijacked, so upgrades and tunnels
+		//go straight through
+		_, upgrade := req.Headers.Get("upgrade")
+		if upgrade || req.RequestLine.Method == "CONNECT" {
+			next(w, req)
+			return
+		}
+
+		acceptEncoding, _ := req.Headers.Get("accept-encoding")
+		cw := &compressWriter{
+			PassThrough: response.PassThrough{W: w},
+			opts:        opts,
+			encoding:    Negotiate(acceptEncoding),
+		}
+		next(w.Wrap(cw), req)
+	}
+}
+
+// Negotiate picks the coding to use for an Accept-Encoding header, or "" for
+// identity (RFC 9110 section 12.5.3).
+func Negotiate(acceptEncoding string) string {
+	type coding struct {
+		name string
+		q    float64
+	}
+	codings := []coding{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		codings = append(codings, coding{name, q})
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, name := range supported {
+		q := wildcard
+		for _, c := range codings {
+			if c.name == name {
+				q = c.q
+			}
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter is the Transport behind the Writer handlers see. It decides
+// once the headers are known whether to compress, and writes the result to the
+// real Writer.
+type compressWriter struct {
+	response.PassThrough
+	opts     *Options
+	encoding string
+
+	chunked  bool
+	compress bool
+
+	//enc compresses into bw, which collects the output into chunks
+	enc io.WriteCloser
+	bw  *bufio.Writer
+}
+
+// chunkSize is how much compressed output makes a chunk, the encoders
+// themselves write out a few hundred bytes at a time.
+const chunkSize = 32 << 10
+
+func (c *compressWriter) WriteHeader(statusCode response.StatusCode, h headers.Headers) error {
+	c.chunked = h.IsChunked()
+
+	//ranges count bytes of the uncompressed body, so partial content is left
+	//alone
+	eligible := response.BodyAllowed(statusCode) && statusCode != response.StatusPartialContent && c.compressible(h)
+	if eligible {
+		//the body depends on Accept-Encoding even when we end up not
+		//compressing it, so caches have to know
+		vary, _ := h.Get("vary")
+		if !headers.HasToken(vary, "accept-encoding") {
+			h.Set("Vary", "Accept-Encoding")
+		}
+	}
+	if length, ok := h.Get("content-length"); ok {
+		n, err := strconv.Atoi(length)
+		if err == nil && n < c.minSize() {
+			eligible = false
+		}
+	}
+	c.compress = eligible && c.encoding != ""
+
+	if !c.compress {
+		return c.PassThrough.WriteHeader(statusCode, h)
+	}
+
+	//the compressed length is only known at the end
+	h.ForceRemoveHeader("content-length")
+	h.ForceSet("Transfer-Encoding", "chunked")
+	c.setEncodingHeaders(h)
+	err := c.PassThrough.WriteHeader(statusCode, h)
+	if err != nil {
+		return err
+	}
+	c.bw = bufio.NewWriterSize(response.NewChunkWriter(c.W), chunkSize)
+	c.enc, err = c.newEncoder(c.bw)
+	return err
+}
+
+func (c *compressWriter) WriteBody(p []byte) (int, error) {
+	switch {
+	case !c.compress:
+		return c.PassThrough.WriteBody(p)
+	case c.chunked:
+		_, err := c.enc.Write(p)
+		if err != nil {
+			return 0, err
+		}
+		return len(p), c.flushEncoder()
+	}
+	return c.enc.Write(p)
+}
+
+// WriteBodyFrom hands streamed bodies we don't compress to the real Writer as
+// a reader, which keeps large files such as videos out of memory.
+func (c *compressWriter) WriteBodyFrom(r io.Reader) (int64, error) {
+	if !c.compress {
+		return c.PassThrough.WriteBodyFrom(r)
+	}
+	return io.Copy(c.enc, r)
+}
+
+func (c *compressWriter) Finish(trailers headers.Headers) error {
+	if c.compress {
+		err := c.enc.Close()
+		if err == nil {
+			err = c.bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return c.PassThrough.Finish(trailers)
+}
+
+// Flush pushes what was written so far to the client, through the encoder
+// when compressing.
+func (c *compressWriter) Flush() error {
+	if c.compress {
+		err := c.flushEncoder()
+		if err != nil {
+			return err
+		}
+	}
+	return c.PassThrough.Flush()
+}
+
+// flushEncoder sends what the encoder holds as a chunk.
+func (c *compressWriter) flushEncoder() error {
+	err := c.enc.(flusher).Flush()
+	if err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+func (c *compressWriter) setEncodingHeaders(h headers.Headers) {
+	h.ForceSet("Content-Encoding", c.encoding)
+	h.ForceRemoveHeader("accept-ranges")
+	//the compressed body is no longer byte for byte the entity a strong
+	//validator was computed for
+	if etag, ok := h.Get("etag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.ForceSet("ETag", "W/"+etag)
+	}
+}
+
+func (c *compressWriter) compressible(h headers.Headers) bool {
+	if _, ok := h.Get("content-encoding"); ok {
+		return false
+	}
+	contentType, _ := h.Get("content-type")
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range compressedTypes {
+		if strings.HasPrefix(mediaType, t) {
+			return false
+		}
+	}
+
+	allowed := c.opts.ContentTypes
+	if len(allowed) == 0 {
+		allowed = DefaultContentTypes
+	}
+	for _, t := range allowed {
+		if strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compressWriter) minSize() int {
+	if c.opts.MinSize > 0 {
+		return c.opts.MinSize
+	}
+	return 1024
+}
+
+func (c *compressWriter) newEncoder(w io.Writer) (io.WriteCloser, error) {
+	level := c.opts.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if c.encoding == "gzip" {
+		return gzip.NewWriterLevel(w, level)
+	}
+	//HTTP's deflate coding is the zlib format (RFC 9110 section 8.4.1.2)
+	return zlib.NewWriterLevel(w, level)
+}
+
+type flusher interface {
+	Flush() error
+}
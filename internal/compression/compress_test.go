@@ -0,0 +1,193 @@
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+// rawClient leaves responses as they were sent, where net/http would
+// decompress them.
+var rawClient = &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "gzip"},
+		{"br", ""},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.2, gzip;q=0", "deflate"},
+		{"identity", ""},
+		{"X-GZIP; q=0.8", "gzip"},
+		{"gzip;q=abc", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Negotiate(tt.header), "Accept-Encoding: %q", tt.header)
+	}
+}
+
+func TestCompressKnownLength(t *testing.T) {
+	page := strings.Repeat("<p>compress me</p>\n", 200)
+	url := servertest.Start(t, Handler(func(w *response.Writer, req *request.Request) {
+		body := page
+		contentType := "text/html"
+		switch req.RequestLine.RequestTarget {
+		case "/small":
+			body = "tiny"
+		case "/video":
+			contentType = "video/mp4"
+		}
+		hdrs := response.GetDefaultHeaders(len(body))
+		hdrs.ForceSet("Content-Type", contentType)
+		hdrs.Set("ETag", `"v1"`)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(hdrs)
+		w.WriteBody([]byte(body))
+	}, nil))
+
+	// Test: gzip is streamed chunked, in place of the identity length
+	resp, body := servertest.Do(t, rawClient, "GET", url+"/page", "Accept-Encoding", "gzip, deflate")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Less(t, len(body), len(page))
+	reader, err := gzip.NewReader(strings.NewReader(string(body)))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+
+	// Test: deflate is the zlib format
+	resp, body = servertest.Do(t, rawClient, "GET", url+"/page", "Accept-Encoding", "deflate")
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	zr, err := zlib.NewReader(strings.NewReader(string(body)))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+
+	// Test: HEAD gets the headers of GET
+	resp, body = servertest.Do(t, rawClient, "HEAD", url+"/page", "Accept-Encoding", "gzip")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+	assert.Empty(t, body)
+
+	// Test: Clients that don't accept a coding get identity, still with Vary
+	resp, body = servertest.Do(t, rawClient, "GET", url+"/page", "Accept-Encoding", "br")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, page, string(body))
+
+	// Test: Bodies below the minimum size are sent as is
+	resp, body = servertest.Do(t, rawClient, "GET", url+"/small", "Accept-Encoding", "gzip")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "tiny", string(body))
+
+	// Test: Already compressed types are skipped
+	resp, body = servertest.Do(t, rawClient, "GET", url+"/video", "Accept-Encoding", "gzip")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Empty(t, resp.Header.Get("Vary"))
+	assert.Equal(t, page, string(body))
+}
+
+func TestCompressChunked(t *testing.T) {
+	url := servertest.Start(t, Handler(func(w *response.Writer, req *request.Request) {
+		hdrs := headers.NewHeaders()
+		hdrs.Set("Content-Type", "application/json")
+		hdrs.Set("Transfer-Encoding", "chunked")
+		hdrs.Set("Trailer", "X-Count")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(hdrs)
+		for i := 0; i < 50; i++ {
+			w.WriteChunkedBody([]byte(fmt.Sprintf(`{"item": %d}`+"\n", i)))
+		}
+		w.WriteChunkedbodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Count", "50")
+		w.WriteTrailers(trailers)
+	}, nil))
+
+	// Test: Streaming responses are compressed chunk by chunk, keeping trailers
+	resp, body := servertest.Do(t, rawClient, "GET", url+"/stream", "Accept-Encoding", "gzip")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "50", resp.Trailer.Get("X-Count"))
+
+	reader, err := gzip.NewReader(strings.NewReader(string(body)))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(decoded)), "\n")
+	require.Len(t, lines, 50)
+	assert.Equal(t, `{"item": 49}`, lines[49])
+}
+
+func TestCompressStreams(t *testing.T) {
+	release := make(chan struct{})
+	url := servertest.Start(t, Handler(func(w *response.Writer, req *request.Request) {
+		hdrs := response.GetDefaultHeaders(1 << 20)
+		hdrs.ForceSet("Content-Type", "text/plain")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(hdrs)
+		w.Write([]byte(strings.Repeat("a", 1<<19)))
+		<-release
+		w.WriteBody([]byte(strings.Repeat("b", 1<<19)))
+	}, nil))
+
+	// Test: A body of known length isn't held until it is complete
+	req, err := http.NewRequest("GET", url+"/large", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := rawClient.Do(req)
+	close(release)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, 1<<20, len(decoded))
+}
+
+func TestCompressSkipsEventStreams(t *testing.T) {
+	event := strings.Repeat("data: tick\n\n", 200)
+	url := servertest.Start(t, Handler(func(w *response.Writer, req *request.Request) {
+		hdrs := headers.NewHeaders()
+		hdrs.Set("Content-Type", "text/event-stream")
+		hdrs.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(hdrs)
+		w.WriteChunkedBody([]byte(event))
+		w.WriteChunkedbodyDone()
+		w.WriteTrailers(headers.NewHeaders())
+	}, nil))
+
+	// Test: Event streams are sent as is, though text/ is in the allowlist
+	resp, body := servertest.Do(t, rawClient, "GET", url+"/events", "Accept-Encoding", "gzip")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, event, body)
+}
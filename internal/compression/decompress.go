@@ -0,0 +1,111 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+)
+
+// DefaultMaxDecompressedSize caps decompressed request bodies when
+// Decompress is given no limit.
+const DefaultMaxDecompressedSize = 10 << 20
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrBodyTooLarge        = errors.New("decompressed body too large")
+)
+
+// Decompress decodes request bodies sent with Content-Encoding gzip or
+// deflate before next sees them, updating Content-Length and dropping
+// Content-Encoding. The decompressed size is capped at maxSize bytes, so a
+// small zip bomb can't expand in memory. Unsupported codings get 415, bodies
+// over the cap 413 and corrupt ones 400.
+func Decompress(next server.Handler, maxSize int) server.Handler {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+	return func(w *response.Writer, req *request.Request) {
+		contentEncoding, ok := req.Headers.Get("content-encoding")
+		if !ok {
+			next(w, req)
+			return
+		}
+
+		body, err := DecodeBody(req.Body, contentEncoding, maxSize)
+		switch {
+		case errors.Is(err, ErrUnsupportedEncoding):
+			writeError(w, response.StatusUnsupportedMediaType)
+			return
+		case errors.Is(err, ErrBodyTooLarge):
+			writeError(w, response.StatusContentTooLarge)
+			return
+		case err != nil:
+			writeError(w, response.StatusBadRequest)
+			return
+		}
+
+		req.Body = body
+		req.Headers.ForceRemoveHeader("content-encoding")
+		if _, ok := req.Headers.Get("content-length"); ok {
+			req.Headers.ForceSet("content-length", strconv.Itoa(len(body)))
+		}
+		next(w, req)
+	}
+}
+
+// DecodeBody undoes the codings listed in a Content-Encoding header, last
+// applied first.
+func DecodeBody(body []byte, contentEncoding string, maxSize int) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+		var reader io.Reader
+		var err error
+		switch coding {
+		case "identity", "":
+			continue
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			reader, err = zlib.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("%w '%s'", ErrUnsupportedEncoding, coding)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		//read one byte past the cap to tell a body of exactly maxSize from
+		//a larger one
+		body, err = io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxSize {
+			return nil, ErrBodyTooLarge
+		}
+	}
+	return body, nil
+}
+
+func writeError(w *response.Writer, status response.StatusCode) {
+	body := []byte(fmt.Sprintf("%s\n", response.ReasonPhrase(status)))
+	hdrs := response.GetDefaultHeaders(len(body))
+	if status == response.StatusUnsupportedMediaType {
+		//tells the client which codings it may use instead (RFC 9110 section 15.5.16)
+		hdrs.Set("Accept-Encoding", "gzip, deflate")
+	}
+	w.WriteStatusLine(status)
+	w.WriteHeaders(hdrs)
+	w.WriteBody(body)
+}
@@ -0,0 +1,107 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func post(t *testing.T, url, contentEncoding string, body []byte) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", contentEncoding)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+func TestDecompress(t *testing.T) {
+	url := servertest.Start(t, Decompress(func(w *response.Writer, req *request.Request) {
+		_, encoded := req.Headers.Get("content-encoding")
+		length, _ := req.Headers.Get("content-length")
+		body := append([]byte(length+" "), req.Body...)
+		if encoded {
+			body = []byte("still encoded")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, 1024))
+	payload := []byte(`{"agent": "a1", "metrics": [1, 2, 3]}`)
+
+	// Test: gzip bodies reach the handler decoded, with the decoded length
+	resp, body := post(t, url, "gzip", gzipped(t, payload))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "37 "+string(payload), body)
+
+	// Test: deflate is the zlib format
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(payload)
+	zw.Close()
+	resp, body = post(t, url, "deflate", buf.Bytes())
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "37 "+string(payload), body)
+
+	// Test: Unsupported codings are rejected with 415
+	resp, _ = post(t, url, "br", payload)
+	assert.Equal(t, 415, resp.StatusCode)
+	assert.Equal(t, "gzip, deflate", resp.Header.Get("Accept-Encoding"))
+
+	// Test: Bodies expanding past the cap are rejected with 413
+	bomb := gzipped(t, bytes.Repeat([]byte{0}, 1<<20))
+	resp, _ = post(t, url, "gzip", bomb)
+	assert.Equal(t, 413, resp.StatusCode)
+
+	// Test: Corrupt bodies are rejected with 400
+	resp, _ = post(t, url, "gzip", []byte("not gzip at all"))
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestDecodeBody(t *testing.T) {
+	// Test: Stacked codings are undone last applied first
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(gzipped(t, []byte("layered")))
+	zw.Close()
+	body, err := DecodeBody(buf.Bytes(), "gzip, deflate", 1024)
+	require.NoError(t, err)
+	assert.Equal(t, "layered", string(body))
+
+	// Test: A body of exactly the cap is allowed
+	body, err = DecodeBody(gzipped(t, []byte(strings.Repeat("a", 16))), "gzip", 16)
+	require.NoError(t, err)
+	assert.Len(t, body, 16)
+	_, err = DecodeBody(gzipped(t, []byte(strings.Repeat("a", 17))), "gzip", 16)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: identity is a no-op
+	body, err = DecodeBody([]byte("plain"), "identity", 16)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(body))
+}
@@ -0,0 +1,224 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+const tokenChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&'*+-.^_`|~"
+
+var ErrNoCookie = errors.New("cookie not present")
+
+// SameSite controls whether browsers send the cookie with cross-site
+// requests. SameSiteDefault leaves the attribute out.
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	default:
+		return ""
+	}
+}
+
+// Cookie is a cookie sent by the client, or one to be set with Set-Cookie
+// (RFC 6265). Only Name and Value are filled in for cookies a client sent.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge of 0 leaves the attribute out, a negative one deletes the
+	// cookie right away with Max-Age=0.
+	MaxAge int
+
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Parse returns the cookies in a Cookie header value. Pairs that are not
+// valid are skipped, the way browsers treat them.
+func Parse(line string) []*Cookie {
+	cookies := []*Cookie{}
+	for _, pair := range strings.Split(line, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !isToken(name) || !validValue(value) {
+			continue
+		}
+		if len(value) > 1 && value[0] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// FromRequest returns the cookies the client sent with req.
+func FromRequest(req *request.Request) []*Cookie {
+	line, ok := req.Headers.Get("cookie")
+	if !ok {
+		return []*Cookie{}
+	}
+	return Parse(line)
+}
+
+// Get returns the first cookie called name in req, or ErrNoCookie.
+func Get(req *request.Request, name string) (*Cookie, error) {
+	for _, c := range FromRequest(req) {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, ErrNoCookie
+}
+
+// Set adds c to the response headers as its own Set-Cookie field.
+func Set(h headers.Headers, c *Cookie) error {
+	err := c.Valid()
+	if err != nil {
+		return err
+	}
+	h.Set("Set-Cookie", c.String())
+	return nil
+}
+
+// Valid checks that c can be serialized into a Set-Cookie header browsers
+// will accept.
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("invalid cookie name '%s'", c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("invalid value for cookie '%s'", c.Name)
+	}
+	if strings.ContainsAny(c.Path, ";\x7f") || strings.IndexFunc(c.Path, isControl) != -1 {
+		return fmt.Errorf("invalid path for cookie '%s'", c.Name)
+	}
+	if c.Domain != "" && !validDomain(c.Domain) {
+		return fmt.Errorf("invalid domain '%s' for cookie '%s'", c.Domain, c.Name)
+	}
+	if !c.Expires.IsZero() && c.Expires.UTC().Year() < 1601 {
+		return fmt.Errorf("invalid expiry for cookie '%s'", c.Name)
+	}
+
+	//browsers drop these without Secure
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie '%s' with SameSite=None has to be Secure", c.Name)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("partitioned cookie '%s' has to be Secure", c.Name)
+	}
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		return fmt.Errorf("cookie '%s' has to be Secure", c.Name)
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Domain != "" || c.Path != "/") {
+		return fmt.Errorf("cookie '%s' has to be Secure, with Path=/ and no Domain", c.Name)
+	}
+	return nil
+}
+
+// String serializes c as a Set-Cookie value. It does not validate c, use
+// Valid or Set for that.
+func (c *Cookie) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		sb.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		sb.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		sb.WriteString("; Expires=" + response.FormatHTTPDate(c.Expires))
+	}
+	if c.MaxAge > 0 {
+		sb.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		sb.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		sb.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		sb.WriteString("; Secure")
+	}
+	if c.SameSite != SameSiteDefault {
+		sb.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		sb.WriteString("; Partitioned")
+	}
+	return sb.String()
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(tokenChars, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validValue allows the cookie-octets of RFC 6265 section 4.1.1, optionally
+// in double quotes.
+func validValue(v string) bool {
+	if len(v) > 1 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	for i := 0; i < len(v); i++ {
+		b := v[i]
+		if b <= ' ' || b >= 0x7f || b == '"' || b == ',' || b == ';' || b == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(d string) bool {
+	d = strings.TrimPrefix(d, ".")
+	if d == "" || len(d) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isControl(r rune) bool {
+	return r < ' '
+}
@@ -0,0 +1,116 @@
+package cookie
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs are split on semicolons and quotes are removed
+	cookies := Parse(`session=abc123; theme="dark";  empty=`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, Cookie{Name: "session", Value: "abc123"}, *cookies[0])
+	assert.Equal(t, Cookie{Name: "theme", Value: "dark"}, *cookies[1])
+	assert.Equal(t, Cookie{Name: "empty", Value: ""}, *cookies[2])
+
+	// Test: Invalid pairs are skipped, the rest still parsed
+	cookies = Parse(`noequals; bad name=1; bad=a b; ok=1; =2`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+
+	// Test: Cookies are read from the request, repeated headers included
+	req := &request.Request{Headers: headers.NewHeaders()}
+	_, err := Get(req, "a")
+	assert.ErrorIs(t, err, ErrNoCookie)
+	req.Headers.Set("Cookie", "a=1")
+	req.Headers.Set("Cookie", "b=2; a=3")
+	c, err := Get(req, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", c.Value)
+	c, err = Get(req, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", c.Value)
+}
+
+func TestString(t *testing.T) {
+	// Test: Every attribute is serialized
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Path:        "/docs",
+		Domain:      ".example.com",
+		Expires:     time.Date(2025, 10, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "id=a3fWa; Path=/docs; Domain=example.com; Expires=Tue, 21 Oct 2025 07:28:00 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=None; Partitioned", c.String())
+
+	// Test: A negative MaxAge deletes the cookie
+	c = &Cookie{Name: "id", MaxAge: -1, SameSite: SameSiteStrict}
+	assert.Equal(t, "id=; Max-Age=0; SameSite=Strict", c.String())
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie Cookie
+	}{
+		{"empty name", Cookie{Value: "x"}},
+		{"name with space", Cookie{Name: "a b"}},
+		{"value with semicolon", Cookie{Name: "a", Value: "x;y"}},
+		{"value with comma", Cookie{Name: "a", Value: "x,y"}},
+		{"value with space", Cookie{Name: "a", Value: "x y"}},
+		{"path with semicolon", Cookie{Name: "a", Path: "/;x"}},
+		{"path with newline", Cookie{Name: "a", Path: "/\r\nX-Injected: 1"}},
+		{"bad domain", Cookie{Name: "a", Domain: "exa mple.com"}},
+		{"domain label with hyphen", Cookie{Name: "a", Domain: "-example.com"}},
+		{"expiry before 1601", Cookie{Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{"samesite none without secure", Cookie{Name: "a", SameSite: SameSiteNone}},
+		{"partitioned without secure", Cookie{Name: "a", Partitioned: true}},
+		{"secure prefix without secure", Cookie{Name: "__Secure-a"}},
+		{"host prefix with domain", Cookie{Name: "__Host-a", Secure: true, Path: "/", Domain: "example.com"}},
+		{"host prefix without root path", Cookie{Name: "__Host-a", Secure: true, Path: "/docs"}},
+	}
+	for _, tt := range tests {
+		assert.Error(t, tt.cookie.Valid(), tt.name)
+	}
+
+	// Test: Quoted values and the prefixes used properly are fine
+	assert.NoError(t, (&Cookie{Name: "a", Value: `"quoted"`}).Valid())
+	assert.NoError(t, (&Cookie{Name: "__Host-a", Value: "1", Secure: true, Path: "/"}).Valid())
+}
+
+func TestSet(t *testing.T) {
+	h := response.GetDefaultHeaders(0)
+	require.NoError(t, Set(h, &Cookie{Name: "a", Value: "1", Expires: time.Date(2025, 10, 21, 7, 28, 0, 0, time.UTC)}))
+	require.NoError(t, Set(h, &Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	require.Error(t, Set(h, &Cookie{Name: "c", Value: "x;y"}))
+
+	// Test: Each cookie goes out on its own Set-Cookie line
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(response.StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	lines := []string{}
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if strings.HasPrefix(line, "set-cookie: ") {
+			lines = append(lines, line)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"set-cookie: a=1; Expires=Tue, 21 Oct 2025 07:28:00 GMT",
+		"set-cookie: b=2; HttpOnly",
+	}, lines)
+}
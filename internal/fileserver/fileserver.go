@@ -0,0 +1,324 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// sniffLen is how much of a file is read to guess its type when the
+// extension doesn't tell.
+const sniffLen = 512
+
+// mediaTypes covers common extensions that the system mime tables may lack.
+var mediaTypes = map[string]string{
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".mp3":   "audio/mpeg",
+	".txt":   "text/plain; charset=utf-8",
+	".md":    "text/markdown; charset=utf-8",
+	".ico":   "image/x-icon",
+	".woff2": "font/woff2",
+}
+
+// FileServer serves the files of Root. Requests are mapped onto Root after
+// StripPrefix is removed from the target, and never leave it.
+type FileServer struct {
+	Root        fs.FS
+	StripPrefix string
+	// Listing renders an HTML index for directories without index.html.
+	Listing bool
+}
+
+func New(root fs.FS) *FileServer {
+	return &FileServer{Root: root}
+}
+
+func (s *FileServer) Handler(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		writeStatus(w, response.StatusMethodNotAllowed, "Allow", "GET, HEAD")
+		return
+	}
+
+	reqPath, query, hasQuery := strings.Cut(req.RequestLine.RequestTarget, "?")
+	target := strings.TrimPrefix(reqPath, s.StripPrefix)
+	if target == "" {
+		target = "/"
+	}
+	name, ok := cleanPath(target)
+	if !ok {
+		writeStatus(w, response.StatusBadRequest)
+		return
+	}
+
+	f, err := s.Root.Open(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if info.IsDir() {
+		//relative links in the page only resolve against a trailing slash
+		if !strings.HasSuffix(reqPath, "/") {
+			location := path.Base(reqPath) + "/"
+			if hasQuery {
+				location += "?" + query
+			}
+			writeStatus(w, response.StatusMovedPermanently, "Location", location)
+			return
+		}
+		s.serveDir(w, req, name)
+		return
+	}
+	serveContent(w, req, f, info)
+}
+
+// ServeFile serves the named file of Root, whatever the request target.
+func (s *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) {
+	f, err := s.Root.Open(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		writeStatus(w, response.StatusNotFound)
+		return
+	}
+	serveContent(w, req, f, info)
+}
+
+func (s *FileServer) serveDir(w *response.Writer, req *request.Request, name string) {
+	index := path.Join(name, "index.html")
+	f, err := s.Root.Open(index)
+	if err == nil {
+		defer f.Close()
+		info, err := f.Stat()
+		if err == nil && !info.IsDir() {
+			serveContent(w, req, f, info)
+			return
+		}
+	}
+
+	if !s.Listing {
+		writeStatus(w, response.StatusNotFound)
+		return
+	}
+	entries, err := fs.ReadDir(s.Root, name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var page bytes.Buffer
+	page.WriteString("<!doctype html>\n<meta charset=\"utf-8\">\n<pre>\n")
+	for _, e := range entries {
+		entry := e.Name()
+		if e.IsDir() {
+			entry += "/"
+		}
+		href := (&url.URL{Path: entry}).EscapedPath()
+		//a name with a colon would otherwise be read as a scheme
+		if strings.Contains(strings.SplitN(entry, "/", 2)[0], ":") {
+			href = "./" + href
+		}
+		fmt.Fprintf(&page, "<a href=\"%s\">%s</a>\n", html.EscapeString(href), html.EscapeString(entry))
+	}
+	page.WriteString("</pre>\n")
+
+	hdrs := response.GetDefaultHeaders(page.Len())
+	hdrs.ForceSet("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(hdrs)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	w.WriteBody(page.Bytes())
+}
+
+// serveContent streams f, or the ranges of it the request asks for. The
+// caller closes f.
+func serveContent(w *response.Writer, req *request.Request, f fs.File, info fs.FileInfo) {
+	size := info.Size()
+	//the ETag is weak, and If-Range needs a strong match: an If-Range
+	//with the ETag always gets the whole file, only one with the
+	//Last-Modified date can get a range
+	validators := response.Validators{
+		ETag:         response.WeakETag(info.ModTime(), size),
+		LastModified: info.ModTime(),
+	}
+	if status := response.EvaluatePreconditions(req.RequestLine.Method, req.Headers, validators); status != response.StatusOK {
+		response.WritePrecondition(w, status, validators)
+		return
+	}
+
+	var body io.Reader = f
+	contentType := contentTypeByExtension(info.Name())
+	if contentType == "" {
+		sniffed := make([]byte, sniffLen)
+		n, err := io.ReadFull(f, sniffed)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			writeError(w, err)
+			return
+		}
+		sniffed = sniffed[:n]
+		contentType = http.DetectContentType(sniffed)
+
+		//rewinding keeps the file itself as the body, so it can be sent
+		//without copying through user space
+		if seeker, ok := f.(io.Seeker); ok {
+			_, err = seeker.Seek(0, io.SeekStart)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		} else {
+			body = io.MultiReader(bytes.NewReader(sniffed), f)
+		}
+	}
+
+	hdrs := response.GetDefaultHeaders(int(size))
+	hdrs.ForceSet("Content-Type", contentType)
+	validators.SetHeaders(hdrs)
+	//If-Range dates are checked against the Date the response is sent with
+	now := time.Now()
+	hdrs.Set("Date", response.FormatHTTPDate(now))
+
+	seeker, canSeek := f.(io.Seeker)
+	readerAt, canReadAt := f.(io.ReaderAt)
+	if canSeek {
+		hdrs.Set("Accept-Ranges", "bytes")
+	}
+
+	//Range only applies to GET, and only while If-Range still matches
+	var ranges []byteRange
+	rangeHeader, hasRange := req.Headers.Get("range")
+	if hasRange && canSeek && req.RequestLine.Method == "GET" {
+		ifRange, hasIfRange := req.Headers.Get("if-range")
+		etag, _ := hdrs.Get("etag")
+		if !hasIfRange || ifRangeMatches(ifRange, etag, info.ModTime(), now) {
+			var err error
+			ranges, err = parseRange(rangeHeader, size)
+			if errors.Is(err, errUnsatisfiable) {
+				body := []byte(fmt.Sprintf("%s\n", response.ReasonPhrase(response.StatusRangeNotSatisfiable)))
+				errHdrs := response.GetDefaultHeaders(len(body))
+				errHdrs.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+				w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+				w.WriteHeaders(errHdrs)
+				w.WriteBody(body)
+				return
+			}
+		}
+	}
+	if len(ranges) > 1 && !canReadAt {
+		ranges = nil
+	}
+
+	status := response.StatusOK
+	switch {
+	case len(ranges) == 1:
+		r := ranges[0]
+		_, err := seeker.Seek(r.start, io.SeekStart)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		status = response.StatusPartialContent
+		size = r.length
+		hdrs.ForceSet("Content-Range", r.contentRange(info.Size()))
+		hdrs.ForceSet("Content-Length", strconv.FormatInt(size, 10))
+	case len(ranges) > 1:
+		parts := newMultipartRanges(contentType, size, ranges)
+		status = response.StatusPartialContent
+		body = parts.reader(readerAt)
+		size = parts.length()
+		hdrs.ForceSet("Content-Type", parts.mediaType())
+		hdrs.ForceSet("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	w.WriteStatusLine(status)
+	w.WriteHeaders(hdrs)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	w.WriteBodyFrom(io.LimitReader(body, size))
+}
+
+func contentTypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := mediaTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// cleanPath maps a request path onto a name in the root, refusing anything
+// that tries to climb out of it.
+func cleanPath(target string) (string, bool) {
+	if !strings.HasPrefix(target, "/") {
+		return "", false
+	}
+	decoded, err := url.PathUnescape(target)
+	if err != nil || strings.ContainsAny(decoded, "\\\x00") {
+		return "", false
+	}
+	for _, segment := range strings.Split(decoded, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+
+	name := strings.TrimPrefix(path.Clean(decoded), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+	return name, true
+}
+
+func writeError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		writeStatus(w, response.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		writeStatus(w, response.StatusForbidden)
+	default:
+		writeStatus(w, response.StatusInternalServerError)
+	}
+}
+
+// writeStatus sends a plain text response with the reason phrase, plus any
+// extra header name and value pairs.
+func writeStatus(w *response.Writer, status response.StatusCode, extra ...string) {
+	body := []byte(fmt.Sprintf("%s\n", response.ReasonPhrase(status)))
+	hdrs := response.GetDefaultHeaders(len(body))
+	for i := 0; i+1 < len(extra); i += 2 {
+		hdrs.Set(extra[i], extra[i+1])
+	}
+	w.WriteStatusLine(status)
+	w.WriteHeaders(hdrs)
+	w.WriteBody(body)
+}
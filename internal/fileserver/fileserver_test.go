@@ -0,0 +1,144 @@
+package fileserver
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+var testFS = fstest.MapFS{
+	"hello.txt":            {Data: []byte("hello world\n")},
+	"clip.mp4":             {Data: []byte("\x00\x00\x00\x18ftypmp42 not really a video")},
+	"noext":                {Data: []byte("<html><body>sniffed</body></html>")},
+	"site/index.html":      {Data: []byte("<h1>index</h1>")},
+	"docs/a <b>.txt":       {Data: []byte("a")},
+	"docs/nested/deep.txt": {Data: []byte("deep")},
+}
+
+// noRedirects returns redirects instead of following them.
+var noRedirects = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}}
+
+// rawStatus sends target as is, without a client cleaning it up first.
+func rawStatus(t *testing.T, base, target string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: localhost\r\n\r\n", target)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	return strings.TrimSpace(line)
+}
+
+func TestFileServer(t *testing.T) {
+	fsrv := New(testFS)
+	fsrv.StripPrefix = "/static"
+	fsrv.Listing = true
+	base := servertest.Start(t, fsrv.Handler) + "/static"
+
+	// Test: Files are served with their type from the extension
+	resp, body := servertest.Get(t, noRedirects, base+"/hello.txt")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, int64(12), resp.ContentLength)
+	assert.Equal(t, "hello world\n", body)
+
+	resp, _ = servertest.Get(t, noRedirects, base+"/clip.mp4")
+	assert.Equal(t, "video/mp4", resp.Header.Get("Content-Type"))
+
+	// Test: Files without an extension are sniffed, and still sent whole
+	resp, body = servertest.Get(t, noRedirects, base+"/noext")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "<html><body>sniffed</body></html>", body)
+
+	// Test: index.html is served for its directory
+	resp, body = servertest.Get(t, noRedirects, base+"/site/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "<h1>index</h1>", body)
+
+	// Test: Directories without the trailing slash are redirected
+	resp, _ = servertest.Get(t, noRedirects, base+"/site?x=1")
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "site/?x=1", resp.Header.Get("Location"))
+
+	// Test: Listings escape names
+	resp, body = servertest.Get(t, noRedirects, base+"/docs/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, body, `<a href="a%20%3Cb%3E.txt">a &lt;b&gt;.txt</a>`)
+	assert.Contains(t, body, `<a href="nested/">nested/</a>`)
+
+	// Test: Missing files and other methods
+	resp, _ = servertest.Get(t, noRedirects, base+"/missing.txt")
+	assert.Equal(t, 404, resp.StatusCode)
+	resp, err := http.Post(base+"/hello.txt", "text/plain", strings.NewReader("x"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+
+	// Test: HEAD sends the headers only
+	resp, err = http.Head(base + "/hello.txt")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int64(12), resp.ContentLength)
+}
+
+func TestTraversal(t *testing.T) {
+	fsrv := New(testFS)
+	base := servertest.Start(t, fsrv.Handler)
+
+	// Test: Dot dot segments, plain or encoded, are refused
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", rawStatus(t, base, "/../hello.txt"))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", rawStatus(t, base, "/docs/%2e%2e/%2e%2e/etc/passwd"))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", rawStatus(t, base, "/docs/..%2f..%2fhello.txt"))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", rawStatus(t, base, "/docs\\..\\hello.txt"))
+
+	// Test: Without listings, a directory with no index is not found
+	assert.Equal(t, "HTTP/1.1 404 Not Found", rawStatus(t, base, "/docs/"))
+	assert.Equal(t, "HTTP/1.1 200 OK", rawStatus(t, base, "/docs/nested/deep.txt"))
+}
+
+func TestConditionalRequests(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{"page.html": {Data: []byte("<p>page</p>"), ModTime: modTime}}
+	url := servertest.Start(t, New(fsys).Handler) + "/page.html"
+
+	// Test: Files carry a weak ETag and Last-Modified
+	resp, _ := servertest.Get(t, noRedirects, url)
+	etag := resp.Header.Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", resp.Header.Get("Last-Modified"))
+
+	conditional := func(name, value string) *http.Response {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		req.Header.Set(name, value)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// Test: Revalidation with either validator gets 304 with the validators
+	resp = conditional("If-None-Match", etag)
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	resp = conditional("If-Modified-Since", "Wed, 01 May 2024 12:00:00 GMT")
+	assert.Equal(t, 304, resp.StatusCode)
+
+	// Test: A failed If-Match gets 412
+	resp = conditional("If-Match", `"other"`)
+	assert.Equal(t, 412, resp.StatusCode)
+}
@@ -0,0 +1,167 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// maxRanges bounds how many ranges one request may ask for before the
+// Range header is ignored and the whole file sent instead.
+const maxRanges = 32
+
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+// byteRange is a validated range within a file of known size.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header against a file of size bytes
+// (RFC 9110 section 14.1.2). A nil result means the header is to be ignored
+// and the whole file sent, either because it is malformed or because serving
+// it would cost more than the file itself. errUnsatisfiable is returned when
+// no range overlaps the file.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, specs, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil
+	}
+
+	ranges := []byteRange{}
+	var total int64
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		var r byteRange
+		if first == "" {
+			//suffix range, the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+			}
+			if start >= size {
+				continue
+			}
+			end = min(end, size-1)
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+		total += r.length
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	if len(ranges) > maxRanges || (len(ranges) > 1 && total > size) {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether the validator in an If-Range header still
+// describes the file, in which case the Range header applies
+// (RFC 9110 section 13.1.5). Entity tags need a strong match, dates an exact
+// one, and a date is only strong when the file was last modified at least a
+// second before date, the Date of the response; a file changed within the
+// same second could have changed again since.
+func ifRangeMatches(ifRange, etag string, modTime, date time.Time) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	since, err := response.ParseHTTPDate(ifRange)
+	if err != nil || modTime.IsZero() || modTime.After(date.Add(-time.Second)) {
+		return false
+	}
+	return modTime.Truncate(time.Second).Equal(since)
+}
+
+// multipartRanges lays out a multipart/byteranges body
+// (RFC 9110 section 14.6).
+type multipartRanges struct {
+	boundary    string
+	contentType string
+	size        int64
+	ranges      []byteRange
+}
+
+func newMultipartRanges(contentType string, size int64, ranges []byteRange) *multipartRanges {
+	b := make([]byte, 15)
+	rand.Read(b)
+	return &multipartRanges{
+		boundary:    hex.EncodeToString(b),
+		contentType: contentType,
+		size:        size,
+		ranges:      ranges,
+	}
+}
+
+func (m *multipartRanges) mediaType() string {
+	return "multipart/byteranges; boundary=" + m.boundary
+}
+
+func (m *multipartRanges) partHeader(r byteRange) string {
+	return fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+		m.boundary, m.contentType, r.contentRange(m.size))
+}
+
+func (m *multipartRanges) trailer() string {
+	return fmt.Sprintf("\r\n--%s--\r\n", m.boundary)
+}
+
+// length is the exact body length, so it can go in Content-Length before
+// anything is read.
+func (m *multipartRanges) length() int64 {
+	var n int64
+	for _, r := range m.ranges {
+		n += int64(len(m.partHeader(r))) + r.length
+	}
+	return n + int64(len(m.trailer()))
+}
+
+// reader returns the body, reading each range from f as it is reached.
+func (m *multipartRanges) reader(f io.ReaderAt) io.Reader {
+	parts := []io.Reader{}
+	for _, r := range m.ranges {
+		parts = append(parts, strings.NewReader(m.partHeader(r)), io.NewSectionReader(f, r.start, r.length))
+	}
+	parts = append(parts, strings.NewReader(m.trailer()))
+	return io.MultiReader(parts...)
+}
@@ -0,0 +1,156 @@
+package fileserver
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{"bytes=0-4", []byteRange{{0, 5}}, nil},
+		{"bytes=5-", []byteRange{{5, 5}}, nil},
+		{"bytes=-3", []byteRange{{7, 3}}, nil},
+		{"bytes=-30", []byteRange{{0, 10}}, nil},
+		{"bytes=8-100", []byteRange{{8, 2}}, nil},
+		{"bytes=0-1, 4-5", []byteRange{{0, 2}, {4, 2}}, nil},
+		{"bytes=0-1,20-30", []byteRange{{0, 2}}, nil},
+		{"bytes=10-", nil, errUnsatisfiable},
+		{"bytes=-0", nil, errUnsatisfiable},
+		{"bytes=5-2", nil, nil},
+		{"bytes=a-b", nil, nil},
+		{"items=0-1", nil, nil},
+		{"bytes=0-9,0-9", nil, nil},
+	}
+	for _, tt := range tests {
+		ranges, err := parseRange(tt.header, 10)
+		assert.Equal(t, tt.err, err, tt.header)
+		assert.Equal(t, tt.want, ranges, tt.header)
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	date := modTime.Add(time.Hour)
+
+	// Test: Dates must match exactly, to the second
+	assert.True(t, ifRangeMatches("Wed, 01 May 2024 12:00:00 GMT", "", modTime, date))
+	assert.False(t, ifRangeMatches("Wed, 01 May 2024 11:59:59 GMT", "", modTime, date))
+
+	// Test: Dates only match once the file is a second older than the
+	// response
+	assert.True(t, ifRangeMatches("Wed, 01 May 2024 12:00:00 GMT", "", modTime, modTime.Add(time.Second)))
+	assert.False(t, ifRangeMatches("Wed, 01 May 2024 12:00:00 GMT", "", modTime, modTime.Add(999*time.Millisecond)))
+
+	// Test: Entity tags need a strong match
+	assert.True(t, ifRangeMatches(`"abc"`, `"abc"`, modTime, date))
+	assert.False(t, ifRangeMatches(`"abc"`, `"abd"`, modTime, date))
+	assert.False(t, ifRangeMatches(`W/"abc"`, `W/"abc"`, modTime, date))
+	assert.False(t, ifRangeMatches(`"abc"`, "", modTime, date))
+}
+
+func TestRangeRequests(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"digits.txt": {Data: []byte("0123456789"), ModTime: modTime},
+	}
+	url := servertest.Start(t, New(fsys).Handler) + "/digits.txt"
+
+	request := func(headers ...string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	// Test: Full responses advertise range support
+	resp, body := request()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, "0123456789", body)
+
+	// Test: A single range
+	resp, body = request("Range", "bytes=2-5")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 2-5/10", resp.Header.Get("Content-Range"))
+	assert.Equal(t, int64(4), resp.ContentLength)
+	assert.Equal(t, "2345", body)
+
+	// Test: Several ranges as multipart/byteranges
+	resp, body = request("Range", "bytes=0-1,-2")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for _, want := range []struct{ contentRange, data string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 8-9/10", "89"},
+	} {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, want.data, string(data))
+	}
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Ranges past the end are unsatisfiable
+	resp, _ = request("Range", "bytes=10-20")
+	assert.Equal(t, 416, resp.StatusCode)
+	assert.Equal(t, "bytes */10", resp.Header.Get("Content-Range"))
+
+	// Test: If-Range with a stale date gets the whole file
+	resp, body = request("Range", "bytes=0-0", "If-Range", "Tue, 30 Apr 2024 12:00:00 GMT")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+
+	resp, body = request("Range", "bytes=0-0", "If-Range", "Wed, 01 May 2024 12:00:00 GMT")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "0", body)
+
+	// Test: If-Range with the ETag gets the whole file, the ETag being weak
+	resp, _ = request()
+	resp, body = request("Range", "bytes=0-0", "If-Range", resp.Header.Get("ETag"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+}
+
+func TestIfRangeJustModified(t *testing.T) {
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"fresh.txt": {Data: []byte("0123456789"), ModTime: modTime},
+	}
+	url := servertest.Start(t, New(fsys).Handler) + "/fresh.txt"
+
+	// Test: If-Range with the date of a file modified within the second of
+	// the response gets the whole file
+	resp, body := servertest.Do(t, nil, "GET", url, "Range", "bytes=0-0", "If-Range", response.FormatHTTPDate(modTime))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+}
@@ -0,0 +1,303 @@
+package form
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"strings"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+)
+
+const (
+	DefaultMaxMemory   = 10 << 20
+	DefaultMaxParts    = 1000
+	DefaultMaxPartSize = 100 << 20
+)
+
+var (
+	ErrUnsupportedContentType = &statusError{415, "unsupported form content type"}
+	ErrTooManyParts           = &statusError{413, "form has too many parts"}
+	ErrPartTooLarge           = &statusError{413, "form part too large"}
+	ErrFormTooLarge           = &statusError{413, "form values too large"}
+)
+
+// statusError is a form error that knows the status code to answer it with.
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func (e *statusError) HTTPStatus() int {
+	return e.status
+}
+
+// Limits bound what parsing a form may cost. Zero fields take the defaults.
+type Limits struct {
+	// MaxMemory is how many bytes of the form are kept in memory. Values
+	// have to fit in it; files that don't are spilled to temporary files.
+	MaxMemory int64
+	// MaxParts caps the parts of a multipart form, or the pairs of an
+	// urlencoded one.
+	MaxParts int
+	// MaxPartSize caps a single file.
+	MaxPartSize int64
+	// TempDir is where spilled files go, os.TempDir() by default.
+	TempDir string
+}
+
+func (l *Limits) maxMemory() int64 {
+	if l == nil || l.MaxMemory <= 0 {
+		return DefaultMaxMemory
+	}
+	return l.MaxMemory
+}
+
+func (l *Limits) maxParts() int {
+	if l == nil || l.MaxParts <= 0 {
+		return DefaultMaxParts
+	}
+	return l.MaxParts
+}
+
+func (l *Limits) maxPartSize() int64 {
+	if l == nil || l.MaxPartSize <= 0 {
+		return DefaultMaxPartSize
+	}
+	return l.MaxPartSize
+}
+
+func (l *Limits) tempDir() string {
+	if l == nil {
+		return ""
+	}
+	return l.TempDir
+}
+
+// Form is a parsed request body. Call RemoveAll when done with it to delete
+// spilled files.
+type Form struct {
+	Values url.Values
+	Files  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file; Open reads it.
+type FileHeader struct {
+	Filename string
+	Header   headers.Headers
+	Size     int64
+
+	content []byte
+	tmpFile string
+}
+
+// File is an uploaded file opened for reading.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+type memoryFile struct {
+	*io.SectionReader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
+
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpFile != "" {
+		return os.Open(fh.tmpFile)
+	}
+	return memoryFile{io.NewSectionReader(bytes.NewReader(fh.content), 0, int64(len(fh.content)))}, nil
+}
+
+// InMemory reports whether the file is held in memory rather than spilled
+// to a temporary file.
+func (fh *FileHeader) InMemory() bool {
+	return fh.tmpFile == ""
+}
+
+// RemoveAll deletes the temporary files of the form.
+func (f *Form) RemoveAll() error {
+	var err error
+	for _, fhs := range f.Files {
+		for _, fh := range fhs {
+			if fh.tmpFile == "" {
+				continue
+			}
+			rmErr := os.Remove(fh.tmpFile)
+			if rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) && err == nil {
+				err = rmErr
+			}
+		}
+	}
+	return err
+}
+
+// Parse parses the body of req as application/x-www-form-urlencoded or
+// multipart/form-data, going by its Content-Type. Other types give
+// ErrUnsupportedContentType, forms over limits one of ErrTooManyParts,
+// ErrPartTooLarge or ErrFormTooLarge. The query string is not included.
+//
+// req.Body was read into memory by the request parser, so it's
+// request.MaxBodyBytes that bounds the memory a form takes, whatever limits
+// say: spilling files past MaxMemory only matters for a MultipartReader
+// reading a stream.
+func Parse(req *request.Request, limits *Limits) (*Form, error) {
+	contentType, _ := req.Headers.Get("content-type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedContentType
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if int64(len(req.Body)) > limits.maxMemory() {
+			return nil, ErrFormTooLarge
+		}
+		values, err := ParseURLEncoded(string(req.Body), limits.maxParts())
+		if err != nil {
+			return nil, err
+		}
+		return &Form{Values: values, Files: map[string][]*FileHeader{}}, nil
+	case "multipart/form-data":
+		boundary := params["boundary"]
+		if boundary == "" || len(boundary) > 70 {
+			return nil, fmt.Errorf("invalid multipart boundary")
+		}
+		return NewMultipartReader(bytes.NewReader(req.Body), boundary).ReadForm(limits)
+	}
+	return nil, ErrUnsupportedContentType
+}
+
+// ParseURLEncoded parses name=value pairs separated by '&', with '+' for
+// spaces and percent-encoding. Semicolons are not separators, as in the URL
+// standard.
+func ParseURLEncoded(s string, maxPairs int) (url.Values, error) {
+	values := url.Values{}
+	pairs := 0
+	for _, pair := range strings.Split(s, "&") {
+		if pair == "" {
+			continue
+		}
+		pairs++
+		if maxPairs > 0 && pairs > maxPairs {
+			return nil, ErrTooManyParts
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid form field name: %w", err)
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for form field '%s': %w", name, err)
+		}
+		values.Add(name, value)
+	}
+	return values, nil
+}
+
+// ReadForm reads every part into a Form. Values and files are kept in
+// memory up to limits.MaxMemory in total; a file that doesn't fit is written
+// to a temporary file instead. Parts without a name are skipped.
+func (mr *MultipartReader) ReadForm(limits *Limits) (*Form, error) {
+	form := &Form{Values: url.Values{}, Files: map[string][]*FileHeader{}}
+	err := mr.readForm(form, limits)
+	if err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+	return form, nil
+}
+
+func (mr *MultipartReader) readForm(form *Form, limits *Limits) error {
+	memory := limits.maxMemory()
+	maxPartSize := limits.maxPartSize()
+	for parts := 1; ; parts++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if parts > limits.maxParts() {
+			return ErrTooManyParts
+		}
+		name := p.FormName()
+		if name == "" {
+			continue
+		}
+
+		if !p.IsFile() {
+			var buf bytes.Buffer
+			n, err := io.Copy(&buf, io.LimitReader(p, memory+1))
+			if err != nil {
+				return err
+			}
+			if n > memory {
+				return ErrFormTooLarge
+			}
+			memory -= n
+			form.Values.Add(name, buf.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: p.FileName(), Header: p.Header}
+		content := io.LimitReader(p, maxPartSize+1)
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, io.LimitReader(content, memory+1))
+		if err != nil {
+			return err
+		}
+		if n <= memory {
+			if n > maxPartSize {
+				return ErrPartTooLarge
+			}
+			fh.content = buf.Bytes()
+			fh.Size = n
+			memory -= n
+		} else {
+			fh.tmpFile, fh.Size, err = spill(limits.tempDir(), io.MultiReader(&buf, content))
+			if err != nil {
+				return err
+			}
+			//registered before the size check so RemoveAll cleans it up
+			form.Files[name] = append(form.Files[name], fh)
+			if fh.Size > maxPartSize {
+				return ErrPartTooLarge
+			}
+			continue
+		}
+		form.Files[name] = append(form.Files[name], fh)
+	}
+}
+
+func spill(dir string, r io.Reader) (string, int64, error) {
+	f, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), n, nil
+}
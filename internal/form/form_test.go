@@ -0,0 +1,128 @@
+package form
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+)
+
+func newRequest(contentType string, body []byte) *request.Request {
+	req := &request.Request{Headers: headers.NewHeaders(), Body: body}
+	req.Headers.Set("Content-Type", contentType)
+	return req
+}
+
+// multipartRequest builds a multipart/form-data request with the given
+// fields and files, in that order.
+func multipartRequest(fields map[string]string, files map[string]string) *request.Request {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	for name, content := range files {
+		fw, _ := mw.CreateFormFile(name, name+".txt")
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+	return newRequest(mw.FormDataContentType(), buf.Bytes())
+}
+
+func TestParseURLEncoded(t *testing.T) {
+	// Test: Pairs are decoded, repeated names kept in order
+	values, err := ParseURLEncoded("name=Jane+Doe&tag=a&tag=b%26c&empty=&flag", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe", values.Get("name"))
+	assert.Equal(t, []string{"a", "b&c"}, values["tag"])
+	assert.Equal(t, []string{""}, values["empty"])
+	assert.Equal(t, []string{""}, values["flag"])
+
+	// Test: Semicolons are part of values
+	values, err = ParseURLEncoded("a=1;b=2", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "1;b=2", values.Get("a"))
+
+	_, err = ParseURLEncoded("a=%zz", 0)
+	require.Error(t, err)
+	_, err = ParseURLEncoded("a=1&b=2&c=3", 2)
+	assert.ErrorIs(t, err, ErrTooManyParts)
+}
+
+func TestParse(t *testing.T) {
+	// Test: The Content-Type picks the parser
+	f, err := Parse(newRequest("application/x-www-form-urlencoded; charset=utf-8", []byte("q=go")), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "go", f.Values.Get("q"))
+
+	f, err = Parse(multipartRequest(map[string]string{"title": "report"}, map[string]string{"doc": "contents"}), nil)
+	require.NoError(t, err)
+	defer f.RemoveAll()
+	assert.Equal(t, "report", f.Values.Get("title"))
+	require.Len(t, f.Files["doc"], 1)
+	fh := f.Files["doc"][0]
+	assert.Equal(t, "doc.txt", fh.Filename)
+	assert.Equal(t, int64(8), fh.Size)
+	assert.True(t, fh.InMemory())
+	file, err := fh.Open()
+	require.NoError(t, err)
+	data, _ := io.ReadAll(file)
+	file.Close()
+	assert.Equal(t, "contents", string(data))
+
+	_, err = Parse(newRequest("application/json", []byte("{}")), nil)
+	assert.ErrorIs(t, err, ErrUnsupportedContentType)
+	_, err = Parse(newRequest("multipart/form-data", nil), nil)
+	require.Error(t, err)
+}
+
+func TestParseLimits(t *testing.T) {
+	tmp := t.TempDir()
+	limits := &Limits{MaxMemory: 100, MaxParts: 3, MaxPartSize: 1000, TempDir: tmp}
+
+	// Test: Files past the memory limit are spilled to temporary files,
+	// which RemoveAll deletes
+	req := multipartRequest(map[string]string{"a": "1"}, map[string]string{"small": "tiny", "large": strings.Repeat("x", 500)})
+	f, err := Parse(req, limits)
+	require.NoError(t, err)
+	assert.True(t, f.Files["small"][0].InMemory())
+	large := f.Files["large"][0]
+	assert.False(t, large.InMemory())
+	assert.Equal(t, int64(500), large.Size)
+	file, err := large.Open()
+	require.NoError(t, err)
+	data, _ := io.ReadAll(file)
+	file.Close()
+	assert.Equal(t, strings.Repeat("x", 500), string(data))
+	entries, _ := os.ReadDir(tmp)
+	assert.Len(t, entries, 1)
+	require.NoError(t, f.RemoveAll())
+	entries, _ = os.ReadDir(tmp)
+	assert.Empty(t, entries)
+
+	// Test: Too many parts, oversized files and values are refused, leaving
+	// no temporary files behind
+	req = multipartRequest(map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}, nil)
+	_, err = Parse(req, limits)
+	assert.ErrorIs(t, err, ErrTooManyParts)
+
+	req = multipartRequest(nil, map[string]string{"huge": strings.Repeat("x", 1001)})
+	_, err = Parse(req, limits)
+	assert.ErrorIs(t, err, ErrPartTooLarge)
+	entries, _ = os.ReadDir(tmp)
+	assert.Empty(t, entries)
+
+	req = multipartRequest(map[string]string{"long": strings.Repeat("x", 101)}, nil)
+	_, err = Parse(req, limits)
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+
+	_, err = Parse(newRequest("application/x-www-form-urlencoded", []byte("a="+strings.Repeat("x", 100))), limits)
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+}
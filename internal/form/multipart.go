@@ -0,0 +1,211 @@
+package form
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+)
+
+var errNoCRLF = errors.New("line not ended with CRLF")
+
+// maxPartHeaderBytes caps the header section of a single part.
+const maxPartHeaderBytes = 16 << 10
+
+// MultipartReader reads the parts of a multipart body (RFC 2046 section
+// 5.1, RFC 7578) one after another as they arrive, without holding the body
+// in memory.
+type MultipartReader struct {
+	br       *bufio.Reader
+	boundary string
+	//delim ends the content of a part: CRLF, two dashes and the boundary
+	delim []byte
+
+	part    *Part
+	started bool
+	done    bool
+}
+
+func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		br:       bufio.NewReaderSize(r, 4096+len(boundary)),
+		boundary: boundary,
+		delim:    []byte("\r\n--" + boundary),
+	}
+}
+
+// Part is one part of a multipart body. Reading it returns its content up
+// to the next boundary.
+type Part struct {
+	Header headers.Headers
+
+	mr         *MultipartReader
+	formName   string
+	fileName   string
+	hasFile    bool
+	contentEnd bool
+}
+
+// FormName is the name parameter of the part's Content-Disposition.
+func (p *Part) FormName() string {
+	return p.formName
+}
+
+// FileName is the filename parameter of the part's Content-Disposition,
+// without any directories a client put in front.
+func (p *Part) FileName() string {
+	return p.fileName
+}
+
+// IsFile reports whether the part is a file upload, that is whether its
+// Content-Disposition has a filename parameter, even an empty one.
+func (p *Part) IsFile() bool {
+	return p.hasFile
+}
+
+func (p *Part) Read(b []byte) (int, error) {
+	if p.contentEnd {
+		return 0, io.EOF
+	}
+	br := p.mr.br
+	peek, err := br.Peek(br.Size())
+	if i := bytes.Index(peek, p.mr.delim); i >= 0 {
+		n := copy(b, peek[:i])
+		br.Discard(n)
+		if n == i {
+			p.contentEnd = true
+			if n == 0 {
+				return 0, io.EOF
+			}
+		}
+		return n, nil
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	//the end of the buffer may hold the start of the delimiter
+	safe := len(peek) - len(p.mr.delim) + 1
+	n := copy(b, peek[:safe])
+	br.Discard(n)
+	return n, nil
+}
+
+// NextPart skips what is left of the current part and returns the next one,
+// or io.EOF after the last.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.part != nil {
+		_, err := io.Copy(io.Discard, mr.part)
+		if err != nil {
+			return nil, err
+		}
+		mr.part = nil
+	}
+	if mr.done {
+		return nil, io.EOF
+	}
+
+	if !mr.started {
+		err := mr.skipPreamble()
+		if err != nil {
+			return nil, err
+		}
+		mr.started = true
+	} else {
+		_, err := mr.br.Discard(len(mr.delim))
+		if err != nil {
+			return nil, err
+		}
+		rest, err := mr.readLine()
+		//the close delimiter ends the body, whatever follows is epilogue
+		if strings.HasPrefix(rest, "--") {
+			mr.done = true
+			return nil, io.EOF
+		}
+		//transport padding may follow the boundary
+		if err != nil || strings.TrimRight(rest, " \t") != "" {
+			return nil, fmt.Errorf("malformed multipart boundary")
+		}
+	}
+	if mr.done {
+		return nil, io.EOF
+	}
+
+	h, err := mr.readPartHeaders()
+	if err != nil {
+		return nil, err
+	}
+	p := &Part{Header: h, mr: mr}
+	if disposition, ok := h.Get("content-disposition"); ok {
+		dispType, params, err := mime.ParseMediaType(disposition)
+		if err == nil && dispType == "form-data" {
+			p.formName = params["name"]
+			p.fileName, p.hasFile = params["filename"]
+			if p.fileName != "" {
+				p.fileName = path.Base(strings.ReplaceAll(p.fileName, "\\", "/"))
+			}
+		}
+	}
+	mr.part = p
+	return p, nil
+}
+
+// skipPreamble reads up to and including the first boundary line.
+func (mr *MultipartReader) skipPreamble() error {
+	for {
+		line, err := mr.readLine()
+		if err == bufio.ErrBufferFull || err == errNoCRLF {
+			continue
+		}
+		switch strings.TrimRight(line, " \t") {
+		case "--" + mr.boundary:
+			return nil
+		case "--" + mr.boundary + "--":
+			mr.done = true
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("multipart boundary not found")
+		}
+	}
+}
+
+func (mr *MultipartReader) readPartHeaders() (headers.Headers, error) {
+	h := headers.NewHeaders()
+	total := 0
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("malformed multipart headers: %w", err)
+		}
+		if line == "" {
+			return h, nil
+		}
+		total += len(line)
+		if total > maxPartHeaderBytes {
+			return nil, fmt.Errorf("multipart headers too large")
+		}
+		_, _, err = h.Parse([]byte(line + "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readLine returns the next line without its line ending.
+func (mr *MultipartReader) readLine() (string, error) {
+	line, err := mr.br.ReadSlice('\n')
+	s := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+	if err == nil && !bytes.HasSuffix(line, []byte("\r\n")) {
+		return s, errNoCRLF
+	}
+	return s, err
+}
@@ -0,0 +1,114 @@
+package form
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readParts(t *testing.T, mr *MultipartReader) []string {
+	t.Helper()
+	contents := []string{}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return contents
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(p)
+		require.NoError(t, err)
+		contents = append(contents, p.FormName()+"="+string(data))
+	}
+}
+
+func TestMultipartReader(t *testing.T) {
+	body := "preamble to ignore\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n" +
+		"\r\n" +
+		"hello\r\nworld\r\n" +
+		"--xyz  \r\n" +
+		"Content-Disposition: form-data; name=\"doc\"; filename=\"C:\\\\Users\\\\me\\\\notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"--xy not a boundary\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"empty\"\r\n" +
+		"\r\n" +
+		"\r\n" +
+		"--xyz--\r\n" +
+		"epilogue to ignore"
+
+	// Test: Preamble, epilogue and transport padding are skipped, and
+	// content may contain line breaks and near boundaries
+	mr := NewMultipartReader(strings.NewReader(body), "xyz")
+	assert.Equal(t, []string{"title=hello\r\nworld", "doc=--xy not a boundary", "empty="}, readParts(t, mr))
+
+	// Test: Part headers and file names, without client directories
+	mr = NewMultipartReader(strings.NewReader(body), "xyz")
+	mr.NextPart()
+	p, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.True(t, p.IsFile())
+	assert.Equal(t, "notes.txt", p.FileName())
+	contentType, _ := p.Header.Get("content-type")
+	assert.Equal(t, "text/plain", contentType)
+}
+
+func TestMultipartReaderLargeParts(t *testing.T) {
+	big := make([]byte, 100_000)
+	rand.Read(big)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("upload", "big.bin")
+	fw.Write(big)
+	mw.WriteField("after", "value")
+	mw.Close()
+
+	// Test: Parts much larger than the read buffer come through intact,
+	// wherever the boundary falls
+	mr := NewMultipartReader(&buf, mw.Boundary())
+	p, err := mr.NextPart()
+	require.NoError(t, err)
+	data, err := io.ReadAll(p)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(big, data))
+	p, err = mr.NextPart()
+	require.NoError(t, err)
+	data, _ = io.ReadAll(p)
+	assert.Equal(t, "value", string(data))
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMultipartReaderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no boundary", "just text\r\n"},
+		{"truncated content", "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nno end"},
+		{"truncated headers", "--b\r\nContent-Disposition: form-data"},
+		{"invalid header", "--b\r\nBad Header: x\r\n\r\nx\r\n--b--\r\n"},
+		{"junk after boundary", "--b\r\n\r\nx\r\n--bjunk\r\n\r\ny\r\n--b--\r\n"},
+	}
+	for _, tt := range tests {
+		mr := NewMultipartReader(strings.NewReader(tt.body), "b")
+		var err error
+		for err == nil {
+			var p *Part
+			p, err = mr.NextPart()
+			if err == nil {
+				_, err = io.ReadAll(p)
+			}
+		}
+		assert.NotEqual(t, io.EOF, err, tt.name)
+	}
+}
@@ -71,7 +71,7 @@ func (h Headers) Set(key, val string) {
 		val = strings.Join([]string{
 			v,
 			val,
-		}, ", ")
+		}, separator(key))
 	}
 	h[key] = val
 
@@ -83,11 +83,39 @@ func (h Headers) SetWithoutLower(key, value string) {
 		value = strings.Join([]string{
 			v,
 			value,
-		}, ", ")
+		}, separator(key))
 	}
 	h[key] = value
 }
 
+// Values returns the field lines stored under key. Only Set-Cookie keeps
+// more than one, since its values can't be combined with commas.
+func (h Headers) Values(key string) []string {
+	//keys added without lowering are found as they are
+	val, ok := h[key]
+	if !ok {
+		val, ok = h.Get(key)
+	}
+	if !ok {
+		return nil
+	}
+	return strings.Split(val, "\n")
+}
+
+// separator is how repeated fields are combined. Set-Cookie values can hold
+// commas themselves, in Expires for one, so they are kept apart on separate
+// lines and written out as one field line each (RFC 9110 section 5.3), and
+// Cookie pairs are joined the way clients send them (RFC 6265 section 5.4).
+func separator(key string) string {
+	switch strings.ToLower(key) {
+	case "set-cookie":
+		return "\n"
+	case "cookie":
+		return "; "
+	}
+	return ", "
+}
+
 func (h Headers) ForceRemoveHeader(key string) {
 	key = strings.ToLower(key)
 
@@ -119,3 +147,21 @@ func validateHeaderKey(key string) error {
 	}
 	return nil
 }
+
+// IsChunked reports whether the body is chunked, which the chunked transfer
+// coding has to be the last one listed in Transfer-Encoding for.
+func (h Headers) IsChunked() bool {
+	codings := strings.Split(h["transfer-encoding"], ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// HasToken reports whether the comma separated list value, such as a
+// Connection or Vary header, holds token, ignoring case.
+func HasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -72,3 +72,40 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 24, n)
 	assert.False(t, done)
 }
+
+func TestRepeatedCookieHeaders(t *testing.T) {
+	// Test: Set-Cookie values stay separate, commas in Expires included
+	headers := NewHeaders()
+	headers.Set("Set-Cookie", "a=1; Expires=Wed, 01 May 2024 12:00:00 GMT")
+	headers.Set("set-cookie", "b=2")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 01 May 2024 12:00:00 GMT", "b=2"}, headers.Values("Set-Cookie"))
+
+	// Test: Cookie headers are joined into one list of pairs
+	headers = NewHeaders()
+	_, _, err := headers.Parse([]byte("Cookie: a=1\r\n"))
+	require.NoError(t, err)
+	_, _, err = headers.Parse([]byte("Cookie: b=2\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a=1; b=2", headers["cookie"])
+
+	// Test: Other headers have a single value
+	headers.Set("Accept", "text/html")
+	headers.Set("Accept", "text/plain")
+	assert.Equal(t, []string{"text/html, text/plain"}, headers.Values("accept"))
+	assert.Nil(t, headers.Values("missing"))
+}
+
+func TestTokens(t *testing.T) {
+	// Test: Chunked has to be the last coding
+	headers := NewHeaders()
+	assert.False(t, headers.IsChunked())
+	headers.Set("Transfer-Encoding", "gzip, Chunked")
+	assert.True(t, headers.IsChunked())
+	headers.ForceSet("Transfer-Encoding", "chunked, gzip")
+	assert.False(t, headers.IsChunked())
+
+	// Test: Tokens are matched whole, ignoring case and spaces
+	assert.True(t, HasToken("keep-alive, Upgrade", "upgrade"))
+	assert.False(t, HasToken("upgrade-insecure", "upgrade"))
+	assert.False(t, HasToken("", "upgrade"))
+}
@@ -0,0 +1,117 @@
+package http2_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+func TestPriorKnowledge(t *testing.T) {
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		host, _ := req.Headers.Get("host")
+		body := fmt.Sprintf("%s %s %s %s", req.RequestLine.Method,
+			req.RequestLine.RequestTarget, req.RequestLine.HttpVersion, host)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+	host := strings.TrimPrefix(url, "http://")
+	client := servertest.H2CClient()
+
+	// Test: Concurrent requests share one connection as separate streams
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, body := servertest.Get(t, client, fmt.Sprintf("%s/item/%d", url, i))
+			assert.Equal(t, "HTTP/2.0", resp.Proto)
+			assert.Equal(t, 200, resp.StatusCode)
+			assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+			assert.Empty(t, resp.Header.Get("Connection"))
+			assert.Equal(t, fmt.Sprintf("GET /item/%d 2 %s", i, host), body)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRequestBodyAndTrailers(t *testing.T) {
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		hdrs := headers.NewHeaders()
+		hdrs.Set("Transfer-Encoding", "chunked")
+		hdrs.Set("Trailer", "X-Length")
+		w.WriteHeaders(hdrs)
+		w.WriteChunkedBody(req.Body)
+		w.WriteChunkedBody([]byte("!"))
+		w.WriteChunkedbodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Length", fmt.Sprintf("%d", len(req.Body)))
+		w.WriteTrailers(trailers)
+	})
+
+	// Test: POST body arrives in req.Body and trailers are sent
+	resp, err := servertest.H2CClient().Post(url+"/echo", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello!", string(body))
+	assert.Equal(t, "5", resp.Trailer.Get("X-Length"))
+}
+
+func TestLargeResponse(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(large)))
+		w.WriteBody(large)
+	})
+
+	// Test: A body larger than the default windows and frame size
+	_, body := servertest.Get(t, servertest.H2CClient(), url+"/large")
+	assert.Equal(t, string(large), body)
+}
+
+// zeros reads as an endless stream of zero bytes, of unknown length.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestBodyLimit(t *testing.T) {
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		body := []byte(fmt.Sprint(len(req.Body)))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	client := servertest.H2CClient()
+
+	// Test: A body found to be past request.MaxBodyBytes, without a
+	// content-length, is answered with 413
+	resp, err := client.Post(url+"/", "text/plain", io.LimitReader(zeros{}, request.MaxBodyBytes+1))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 413, resp.StatusCode)
+
+	// Test: A body at the limit is accepted
+	resp, err = client.Post(url+"/", "text/plain", io.LimitReader(zeros{}, request.MaxBodyBytes))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(request.MaxBodyBytes), string(body))
+}
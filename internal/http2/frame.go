@@ -0,0 +1,190 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+const (
+	FlagEndStream  uint8 = 0x1
+	FlagAck        uint8 = 0x1
+	FlagEndHeaders uint8 = 0x4
+	FlagPadded     uint8 = 0x8
+	FlagPriority   uint8 = 0x20
+)
+
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+const (
+	frameHeaderLen       = 9
+	defaultMaxFrameSize  = 16384
+	maxAllowedFrameSize  = 1<<24 - 1
+	defaultWindowSize    = 65535
+	maxWindowSize        = 1<<31 - 1
+	defaultHeaderTableSz = 4096
+)
+
+// ClientPreface is sent by clients before their first frame.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+type Frame struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+func (f *Frame) Has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+// ConnError fails the whole connection with a GOAWAY.
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("http2 connection error %d: %s", e.Code, e.Reason)
+}
+
+// StreamError resets a single stream with RST_STREAM.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("http2 stream %d error %d: %s", e.StreamID, e.Code, e.Reason)
+}
+
+// ReadFrame reads one frame, rejecting frames larger than maxFrameSize.
+func ReadFrame(r io.Reader, maxFrameSize uint32) (*Frame, error) {
+	var head [frameHeaderLen]byte
+	_, err := io.ReadFull(r, head[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2])
+	f := &Frame{
+		Type:     FrameType(head[3]),
+		Flags:    head[4],
+		StreamID: binary.BigEndian.Uint32(head[5:]) & 0x7FFFFFFF,
+	}
+	if length > maxFrameSize {
+		return nil, ConnError{ErrCodeFrameSize, fmt.Sprintf("frame of %d bytes exceeds max frame size", length)}
+	}
+
+	f.Payload = make([]byte, length)
+	_, err = io.ReadFull(r, f.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func WriteFrame(w io.Writer, f *Frame) error {
+	buf := make([]byte, frameHeaderLen, frameHeaderLen+len(f.Payload))
+	length := len(f.Payload)
+	buf[0] = byte(length >> 16)
+	buf[1] = byte(length >> 8)
+	buf[2] = byte(length)
+	buf[3] = byte(f.Type)
+	buf[4] = f.Flags
+	binary.BigEndian.PutUint32(buf[5:], f.StreamID&0x7FFFFFFF)
+	buf = append(buf, f.Payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// stripPadding removes the pad length byte and the padding of DATA and
+// HEADERS frames sent with the PADDED flag.
+func stripPadding(f *Frame) ([]byte, error) {
+	payload := f.Payload
+	if !f.Has(FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) < 1 {
+		return nil, ConnError{ErrCodeFrameSize, "padded frame without pad length"}
+	}
+	padLen := int(payload[0])
+	payload = payload[1:]
+	if padLen > len(payload) {
+		return nil, ConnError{ErrCodeProtocol, "padding longer than frame"}
+	}
+	return payload[:len(payload)-padLen], nil
+}
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+func parseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, ConnError{ErrCodeFrameSize, "settings payload not a multiple of 6"}
+	}
+	settings := []Setting{}
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+	return settings, nil
+}
+
+func settingsPayload(settings []Setting) []byte {
+	buf := []byte{}
+	for _, s := range settings {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(s.ID))
+		buf = binary.BigEndian.AppendUint32(buf, s.Value)
+	}
+	return buf
+}
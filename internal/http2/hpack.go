@@ -0,0 +1,442 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HeaderField is a single decoded header, in the order it was sent.
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+func (f HeaderField) size() int {
+	//every entry costs 32 bytes on top of its name and value (RFC 7541 section 4.1)
+	return len(f.Name) + len(f.Value) + 32
+}
+
+var errHpack = errors.New("hpack: invalid header block")
+
+// ErrHeaderListTooLarge is returned by Decode for blocks whose fields add up
+// to more than the max header list size.
+var ErrHeaderListTooLarge = errors.New("hpack: header list too large")
+
+// staticTable is RFC 7541 Appendix A, indexed from 1.
+var staticTable = []HeaderField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// dynamicTable holds the most recently indexed fields first.
+type dynamicTable struct {
+	entries []HeaderField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.entries = append([]HeaderField{f}, t.entries...)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n int) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	for t.size > t.maxSize && len(t.entries) > 0 {
+		last := t.entries[len(t.entries)-1]
+		t.entries = t.entries[:len(t.entries)-1]
+		t.size -= last.size()
+	}
+}
+
+// Decoder decodes header blocks, keeping the dynamic table between blocks of
+// the same connection.
+type Decoder struct {
+	table dynamicTable
+	// allowedMaxSize is the SETTINGS_HEADER_TABLE_SIZE we advertised, the
+	// upper bound for table size updates sent by the encoder.
+	allowedMaxSize int
+	// maxListSize is the SETTINGS_MAX_HEADER_LIST_SIZE we advertised, 0 for
+	// no limit.
+	maxListSize int
+}
+
+func NewDecoder(maxTableSize int) *Decoder {
+	return &Decoder{
+		table:          dynamicTable{maxSize: maxTableSize},
+		allowedMaxSize: maxTableSize,
+	}
+}
+
+// SetMaxHeaderListSize limits the fields of a block to n bytes, counted the
+// way SETTINGS_MAX_HEADER_LIST_SIZE counts them.
+func (d *Decoder) SetMaxHeaderListSize(n int) {
+	d.maxListSize = n
+}
+
+func (d *Decoder) field(index uint64) (HeaderField, error) {
+	if index == 0 {
+		return HeaderField{}, errHpack
+	}
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], nil
+	}
+	index -= uint64(len(staticTable))
+	if index > uint64(len(d.table.entries)) {
+		return HeaderField{}, errHpack
+	}
+	return d.table.entries[index-1], nil
+}
+
+// Decode decodes a complete header block. A block past the max header list
+// size is still decoded to the end, to keep the dynamic table in sync, and
+// then fails with ErrHeaderListTooLarge.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	fields := []HeaderField{}
+	sawField := false
+	listSize := 0
+	emit := func(f HeaderField) {
+		//a few bytes can reference large table entries again and again, so
+		//fields past the limit are dropped rather than collected
+		listSize += f.size()
+		if d.maxListSize == 0 || listSize <= d.maxListSize {
+			fields = append(fields, f)
+		}
+		sawField = true
+	}
+
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			//indexed header field
+			index, rest, err := readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err := d.field(index)
+			if err != nil {
+				return nil, err
+			}
+			emit(f)
+			block = rest
+		case b&0xC0 == 0x40:
+			//literal with incremental indexing
+			f, rest, err := d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+			emit(f)
+			block = rest
+		case b&0xE0 == 0x20:
+			//dynamic table size update, only allowed at the start of a block
+			if sawField {
+				return nil, errHpack
+			}
+			size, rest, err := readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.allowedMaxSize) {
+				return nil, errHpack
+			}
+			d.table.setMaxSize(int(size))
+			block = rest
+		default:
+			//literal without indexing or never indexed
+			f, rest, err := d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			emit(f)
+			block = rest
+		}
+	}
+	if d.maxListSize > 0 && listSize > d.maxListSize {
+		return nil, ErrHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+func (d *Decoder) readLiteral(block []byte, prefix uint8) (HeaderField, []byte, error) {
+	index, rest, err := readInt(block, prefix)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	var f HeaderField
+	if index > 0 {
+		named, err := d.field(index)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+		f.Name = named.Name
+	} else {
+		f.Name, rest, err = readString(rest)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+	}
+	f.Value, rest, err = readString(rest)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+	return f, rest, nil
+}
+
+// readInt decodes an integer with an n bit prefix (RFC 7541 section 5.1).
+func readInt(block []byte, n uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, errHpack
+	}
+	mask := uint64(1)<<n - 1
+	value := uint64(block[0]) & mask
+	block = block[1:]
+	if value < mask {
+		return value, block, nil
+	}
+
+	var shift uint
+	for {
+		if len(block) == 0 || shift > 56 {
+			return 0, nil, errHpack
+		}
+		b := block[0]
+		block = block[1:]
+		value += uint64(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return value, block, nil
+		}
+	}
+}
+
+func readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, errHpack
+	}
+	huffman := block[0]&0x80 != 0
+	length, rest, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(rest)) < length {
+		return "", nil, errHpack
+	}
+	data := rest[:length]
+	rest = rest[length:]
+	if !huffman {
+		return string(data), rest, nil
+	}
+	s, err := huffmanDecode(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return s, rest, nil
+}
+
+// Encoder encodes header blocks. It never adds to the dynamic table, which
+// keeps it stateless: fields are sent indexed when the static table has an
+// exact match and as literals without indexing otherwise.
+type Encoder struct{}
+
+func (e *Encoder) Encode(fields []HeaderField) []byte {
+	buf := []byte{}
+	for _, f := range fields {
+		nameIndex := 0
+		exact := 0
+		for i, sf := range staticTable {
+			if sf.Name != f.Name {
+				continue
+			}
+			if nameIndex == 0 {
+				nameIndex = i + 1
+			}
+			if sf.Value == f.Value {
+				exact = i + 1
+				break
+			}
+		}
+
+		if exact > 0 {
+			buf = appendInt(buf, 7, 0x80, uint64(exact))
+			continue
+		}
+		buf = appendInt(buf, 4, 0x00, uint64(nameIndex))
+		if nameIndex == 0 {
+			buf = appendString(buf, f.Name)
+		}
+		buf = appendString(buf, f.Value)
+	}
+	return buf
+}
+
+func appendInt(buf []byte, n uint8, flags byte, value uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if value < mask {
+		return append(buf, flags|byte(value))
+	}
+	buf = append(buf, flags|byte(mask))
+	value -= mask
+	for value >= 0x80 {
+		buf = append(buf, byte(value&0x7F)|0x80)
+		value >>= 7
+	}
+	return append(buf, byte(value))
+}
+
+// appendString writes s Huffman encoded when that is shorter.
+func appendString(buf []byte, s string) []byte {
+	if huffmanLen(s) < len(s) {
+		buf = appendInt(buf, 7, 0x80, uint64(huffmanLen(s)))
+		return huffmanEncode(buf, s)
+	}
+	buf = appendInt(buf, 7, 0x00, uint64(len(s)))
+	return append(buf, s...)
+}
+
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      int
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{sym: -1}
+	for sym, code := range huffmanCodes {
+		node := root
+		for i := int(huffmanCodeLen[sym]) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{sym: -1}
+			}
+			node = node.children[bit]
+		}
+		node.sym = sym
+	}
+	return root
+}
+
+func huffmanDecode(data []byte) (string, error) {
+	out := make([]byte, 0, len(data)*8/5)
+	node := huffmanRoot
+	//bits consumed since the last complete symbol, and whether all were ones
+	pending := 0
+	allOnes := true
+
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			node = node.children[bit]
+			if node == nil {
+				return "", errHpack
+			}
+			pending++
+			allOnes = allOnes && bit == 1
+			if node.sym >= 0 {
+				out = append(out, byte(node.sym))
+				node = huffmanRoot
+				pending = 0
+				allOnes = true
+			}
+		}
+	}
+
+	//padding has to be a prefix of EOS shorter than a byte
+	if pending > 7 || !allOnes {
+		return "", fmt.Errorf("hpack: invalid huffman padding")
+	}
+	return string(out), nil
+}
+
+func huffmanLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLen[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+func huffmanEncode(buf []byte, s string) []byte {
+	var acc uint64
+	var n uint
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLen[s[i]] | uint64(huffmanCodes[s[i]])
+		n += uint(huffmanCodeLen[s[i]])
+		for n >= 8 {
+			n -= 8
+			buf = append(buf, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		//pad with the most significant bits of EOS, which are all ones
+		buf = append(buf, byte(acc<<(8-n))|byte(0xFF>>n))
+	}
+	return buf
+}
@@ -0,0 +1,118 @@
+package http2
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func TestDecodeRFCExamples(t *testing.T) {
+	first := []HeaderField{
+		{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+	}
+	second := append(append([]HeaderField{}, first...), HeaderField{"cache-control", "no-cache"})
+	third := []HeaderField{
+		{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"},
+		{":authority", "www.example.com"}, {"custom-key", "custom-value"},
+	}
+
+	// Test: RFC 7541 C.3, requests without Huffman coding
+	d := NewDecoder(4096)
+	fields, err := d.Decode(mustHex(t, "8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d"))
+	require.NoError(t, err)
+	assert.Equal(t, first, fields)
+	assert.Equal(t, 57, d.table.size)
+
+	fields, err = d.Decode(mustHex(t, "8286 84be 5808 6e6f 2d63 6163 6865"))
+	require.NoError(t, err)
+	assert.Equal(t, second, fields)
+
+	fields, err = d.Decode(mustHex(t, "8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65"))
+	require.NoError(t, err)
+	assert.Equal(t, third, fields)
+	assert.Equal(t, 164, d.table.size)
+
+	// Test: RFC 7541 C.4, the same requests with Huffman coding
+	d = NewDecoder(4096)
+	fields, err = d.Decode(mustHex(t, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"))
+	require.NoError(t, err)
+	assert.Equal(t, first, fields)
+
+	fields, err = d.Decode(mustHex(t, "8286 84be 5886 a8eb 1064 9cbf"))
+	require.NoError(t, err)
+	assert.Equal(t, second, fields)
+
+	fields, err = d.Decode(mustHex(t, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"))
+	require.NoError(t, err)
+	assert.Equal(t, third, fields)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	// Test: Index past the end of both tables
+	_, err := NewDecoder(4096).Decode([]byte{0xbe})
+	require.Error(t, err)
+
+	// Test: Table size update after a field
+	_, err = NewDecoder(4096).Decode([]byte{0x82, 0x20})
+	require.Error(t, err)
+
+	// Test: Table size update above the advertised limit
+	_, err = NewDecoder(4096).Decode(mustHex(t, "3fe2 1f"))
+	require.Error(t, err)
+
+	// Test: Truncated string
+	_, err = NewDecoder(4096).Decode([]byte{0x40, 0x05, 'a'})
+	require.Error(t, err)
+
+	// Test: Huffman padding that is not all ones
+	_, err = huffmanDecode([]byte{0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xfe})
+	require.Error(t, err)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	// Test: Huffman encoding matches RFC 7541 C.4.1
+	assert.Equal(t, mustHex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), huffmanEncode(nil, "www.example.com"))
+
+	fields := []HeaderField{
+		{":status", "200"},
+		{":status", "418"},
+		{"content-type", "text/plain"},
+		{"x-custom", strings.Repeat("long value ", 20)},
+		{"x-binary", "\x00\xff"},
+	}
+	var e Encoder
+	block := e.Encode(fields)
+	decoded, err := NewDecoder(4096).Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+
+	// Test: Exact static matches are a single byte
+	assert.Equal(t, []byte{0x88}, e.Encode([]HeaderField{{":status", "200"}}))
+}
+
+func TestDecodeHeaderListSize(t *testing.T) {
+	d := NewDecoder(4096)
+	d.SetMaxHeaderListSize(200)
+
+	// Test: Repeated references to an indexed entry count every time
+	block := []byte{0x40, 0x01, 'x', 0x40}
+	block = append(block, strings.Repeat("v", 0x40)...)
+	block = append(block, 0xbe, 0xbe, 0xbe)
+	_, err := d.Decode(block)
+	require.ErrorIs(t, err, ErrHeaderListTooLarge)
+
+	// Test: The table stays in sync for the next block
+	fields, err := d.Decode([]byte{0xbe})
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{"x", strings.Repeat("v", 0x40)}}, fields)
+}
@@ -0,0 +1,959 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// Handler has the same signature as server.Handler, so every HTTP/1.1 handler
+// can serve HTTP/2 streams too.
+type Handler func(w *response.Writer, req *request.Request)
+
+const (
+	maxConcurrentStreams = 100
+	maxHeaderBlockSize   = 1 << 20
+	// maxHeaderListSize is advertised as SETTINGS_MAX_HEADER_LIST_SIZE and
+	// enforced while decoding, the same limit HTTP/1.1 headers get.
+	maxHeaderListSize = request.MaxHeaderBytes
+	// maxBodySize bounds what a stream's window ever grants. One byte past
+	// request.MaxBodyBytes is let in to tell a body that is too large from
+	// one that is exactly at the limit.
+	maxBodySize = request.MaxBodyBytes + 1
+)
+
+// connectionHeaders are meaningless in HTTP/2 and must not be sent
+// (RFC 9113 section 8.2.2).
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+
+// HasPreface reports whether the connection starts with the HTTP/2 client
+// preface, peeking only as far as needed to rule it out.
+func HasPreface(br *bufio.Reader) bool {
+	for i := 1; i <= len(ClientPreface); i++ {
+		b, err := br.Peek(i)
+		if err != nil || !strings.HasPrefix(ClientPreface, string(b)) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsUpgradeRequest reports whether req asks to switch to h2c
+// (RFC 7540 section 3.2).
+func IsUpgradeRequest(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("upgrade")
+	connection, _ := req.Headers.Get("connection")
+	_, hasSettings := req.Headers.Get("http2-settings")
+	return strings.EqualFold(strings.TrimSpace(upgrade), "h2c") &&
+		headers.HasToken(connection, "upgrade") && headers.HasToken(connection, "http2-settings") && hasSettings
+}
+
+// ServeConn serves an HTTP/2 connection with prior knowledge. reader must
+// start with the client preface. The connection is closed when done.
+func ServeConn(conn net.Conn, reader io.Reader, handler Handler) {
+	sc := newServerConn(conn, reader, handler)
+	sc.serve(nil)
+}
+
+// Upgrade answers an h2c upgrade request with 101 Switching Protocols and
+// serves the connection as HTTP/2, with req becoming stream 1.
+func Upgrade(w *response.Writer, req *request.Request, handler Handler) error {
+	settingsHeader, _ := req.Headers.Get("http2-settings")
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(settingsHeader, "="))
+	if err != nil {
+		return fmt.Errorf("invalid HTTP2-Settings header: %w", err)
+	}
+	settings, err := parseSettings(payload)
+	if err != nil {
+		return err
+	}
+
+	hdrs := headers.NewHeaders()
+	hdrs.Set("Connection", "Upgrade")
+	hdrs.Set("Upgrade", "h2c")
+	err = w.WriteStatusLine(response.StatusSwitchingProtocols)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(hdrs)
+	if err != nil {
+		return err
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return err
+	}
+
+	sc := newServerConn(conn, io.MultiReader(bytes.NewReader(buffered), conn), handler)
+	for _, s := range settings {
+		err = sc.applySetting(s)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	//the upgraded request is stream 1, already half closed by the client
+	upgraded := *req
+	upgraded.RequestLine.HttpVersion = "2"
+	for _, name := range []string{"connection", "upgrade", "http2-settings"} {
+		upgraded.Headers.ForceRemoveHeader(name)
+	}
+	sc.serve(&upgraded)
+	return nil
+}
+
+type streamState int
+
+const (
+	stateOpen streamState = iota
+	stateHalfClosedRemote
+)
+
+type stream struct {
+	id            uint32
+	state         streamState
+	req           *request.Request
+	contentLength int
+	sendWindow    int64
+	recvWindow    int64
+	reset         bool
+	//refused is set once the body is answered with 413, what else the
+	//client sends for it is dropped
+	refused bool
+}
+
+type serverConn struct {
+	conn    net.Conn
+	reader  io.Reader
+	handler Handler
+
+	decoder *Decoder
+	encoder Encoder
+
+	//writeMu keeps frames, and the frames of one header block, contiguous
+	writeMu sync.Mutex
+
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	lastStreamID      uint32
+	sendWindow        int64
+	recvWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	closed            bool
+	//goingAway is set once the peer sent GOAWAY, the connection then ends
+	//when the last stream does
+	goingAway bool
+
+	handlers sync.WaitGroup
+
+	//set while a header block continues in CONTINUATION frames
+	continuationID      uint32
+	headerBlock         []byte
+	headerEndStream     bool
+	headerSelfDependent bool
+}
+
+func newServerConn(conn net.Conn, reader io.Reader, handler Handler) *serverConn {
+	sc := &serverConn{
+		conn:              conn,
+		reader:            reader,
+		handler:           handler,
+		decoder:           NewDecoder(defaultHeaderTableSz),
+		streams:           make(map[uint32]*stream),
+		sendWindow:        defaultWindowSize,
+		recvWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrameSize:  defaultMaxFrameSize,
+	}
+	sc.decoder.SetMaxHeaderListSize(maxHeaderListSize)
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+func (sc *serverConn) serve(upgraded *request.Request) {
+	defer sc.shutdown()
+
+	err := sc.writeFrame(&Frame{
+		Type: FrameSettings,
+		Payload: settingsPayload([]Setting{
+			{SettingMaxConcurrentStreams, maxConcurrentStreams},
+			{SettingEnablePush, 0},
+			{SettingMaxHeaderListSize, maxHeaderListSize},
+		}),
+	})
+	if err != nil {
+		return
+	}
+
+	if upgraded != nil {
+		st := &stream{id: 1, state: stateHalfClosedRemote, req: upgraded, sendWindow: sc.peerInitialWindow}
+		sc.mu.Lock()
+		sc.streams[1] = st
+		sc.lastStreamID = 1
+		sc.mu.Unlock()
+		sc.startHandler(st, sc.handler)
+	}
+
+	preface := make([]byte, len(ClientPreface))
+	_, err = io.ReadFull(sc.reader, preface)
+	if err != nil || string(preface) != ClientPreface {
+		return
+	}
+
+	first := true
+	for {
+		f, err := ReadFrame(sc.reader, defaultMaxFrameSize)
+		if err == nil && first && f.Type != FrameSettings {
+			err = ConnError{ErrCodeProtocol, "first frame is not SETTINGS"}
+		}
+		first = false
+		if err == nil {
+			err = sc.processFrame(f)
+		}
+
+		var streamErr StreamError
+		var connErr ConnError
+		switch {
+		case err == nil:
+		case errors.As(err, &streamErr):
+			sc.resetStream(streamErr.StreamID, streamErr.Code)
+		case errors.As(err, &connErr):
+			log.Printf("http2: %v", connErr)
+			sc.goAway(connErr.Code)
+			return
+		default:
+			return
+		}
+
+		//frames are still read after GOAWAY, the streams left may need
+		//WINDOW_UPDATEs to finish
+		if sc.drained() {
+			return
+		}
+	}
+}
+
+// drained reports whether the peer sent GOAWAY and no stream is left.
+func (sc *serverConn) drained() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.goingAway && len(sc.streams) == 0
+}
+
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	sc.conn.Close()
+	sc.handlers.Wait()
+}
+
+func (sc *serverConn) processFrame(f *Frame) error {
+	if sc.continuationID != 0 && (f.Type != FrameContinuation || f.StreamID != sc.continuationID) {
+		return ConnError{ErrCodeProtocol, "expected CONTINUATION frame"}
+	}
+
+	switch f.Type {
+	case FrameHeaders:
+		return sc.processHeaders(f)
+	case FrameContinuation:
+		return sc.processContinuation(f)
+	case FrameData:
+		return sc.processData(f)
+	case FrameSettings:
+		return sc.processSettings(f)
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+	case FramePing:
+		return sc.processPing(f)
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		sc.mu.Lock()
+		sc.goingAway = true
+		sc.mu.Unlock()
+		return nil
+	case FramePriority:
+		if f.StreamID == 0 {
+			return ConnError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.Payload) != 5 {
+			return StreamError{f.StreamID, ErrCodeFrameSize, "PRIORITY frame must be 5 bytes"}
+		}
+		return nil
+	case FramePushPromise:
+		return ConnError{ErrCodeProtocol, "clients cannot push"}
+	default:
+		//unknown frame types are ignored
+		return nil
+	}
+}
+
+func (sc *serverConn) processHeaders(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+	block, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	selfDependent := false
+	if f.Has(FlagPriority) {
+		if len(block) < 5 {
+			return ConnError{ErrCodeFrameSize, "HEADERS too short for priority"}
+		}
+		selfDependent = binary.BigEndian.Uint32(block)&0x7FFFFFFF == f.StreamID
+		block = block[5:]
+	}
+
+	if !f.Has(FlagEndHeaders) {
+		sc.continuationID = f.StreamID
+		sc.headerBlock = append([]byte{}, block...)
+		sc.headerEndStream = f.Has(FlagEndStream)
+		sc.headerSelfDependent = selfDependent
+		return nil
+	}
+	return sc.processHeaderBlock(f.StreamID, block, f.Has(FlagEndStream), selfDependent)
+}
+
+func (sc *serverConn) processContinuation(f *Frame) error {
+	if sc.continuationID == 0 {
+		return ConnError{ErrCodeProtocol, "unexpected CONTINUATION frame"}
+	}
+	sc.headerBlock = append(sc.headerBlock, f.Payload...)
+	if len(sc.headerBlock) > maxHeaderBlockSize {
+		return ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	if !f.Has(FlagEndHeaders) {
+		return nil
+	}
+	id, block, endStream, selfDependent := sc.continuationID, sc.headerBlock, sc.headerEndStream, sc.headerSelfDependent
+	sc.continuationID = 0
+	sc.headerBlock = nil
+	return sc.processHeaderBlock(id, block, endStream, selfDependent)
+}
+
+func (sc *serverConn) processHeaderBlock(id uint32, block []byte, endStream, selfDependent bool) error {
+	//the block has to be decoded even for refused streams to keep the
+	//dynamic table in sync with the client
+	fields, err := sc.decoder.Decode(block)
+	tooLarge := errors.Is(err, ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return ConnError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[id]
+	sc.mu.Unlock()
+
+	if !exists {
+		if id%2 == 0 || id <= sc.lastStreamID {
+			return ConnError{ErrCodeProtocol, fmt.Sprintf("invalid stream id %d", id)}
+		}
+		sc.lastStreamID = id
+	}
+	if selfDependent {
+		return StreamError{id, ErrCodeProtocol, "stream depends on itself"}
+	}
+	if tooLarge {
+		return StreamError{id, ErrCodeEnhanceYourCalm, err.Error()}
+	}
+
+	if exists {
+		if st.refused {
+			return nil
+		}
+		//a second header block on an open stream carries trailers
+		if st.state != stateOpen {
+			return StreamError{id, ErrCodeStreamClosed, "HEADERS on a half closed stream"}
+		}
+		if !endStream {
+			return StreamError{id, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		for _, field := range fields {
+			if strings.HasPrefix(field.Name, ":") {
+				return StreamError{id, ErrCodeProtocol, "pseudo header in trailers"}
+			}
+			st.req.Trailers.Set(field.Name, field.Value)
+		}
+		return sc.endRequest(st)
+	}
+
+	req, contentLength, err := sc.newRequest(fields)
+	if err != nil {
+		return StreamError{id, ErrCodeProtocol, err.Error()}
+	}
+
+	sc.mu.Lock()
+	if sc.goingAway {
+		sc.mu.Unlock()
+		return StreamError{id, ErrCodeRefusedStream, "stream after GOAWAY"}
+	}
+	if len(sc.streams) >= maxConcurrentStreams {
+		sc.mu.Unlock()
+		return StreamError{id, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+	st = &stream{
+		id:            id,
+		state:         stateOpen,
+		req:           req,
+		contentLength: contentLength,
+		sendWindow:    sc.peerInitialWindow,
+		recvWindow:    defaultWindowSize,
+	}
+	sc.streams[id] = st
+	sc.mu.Unlock()
+
+	if endStream {
+		return sc.endRequest(st)
+	}
+	if contentLength > request.MaxBodyBytes {
+		sc.refuseBody(st)
+	}
+	return nil
+}
+
+// newRequest maps the decoded header fields onto a request
+// (RFC 9113 section 8.3.1).
+func (sc *serverConn) newRequest(fields []HeaderField) (*request.Request, int, error) {
+	req := request.NewRequest()
+	req.RequestLine.HttpVersion = "2"
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+	req.State = request.StateDone
+
+	pseudo := map[string]string{}
+	values := map[string][]string{}
+	names := []string{}
+	regular := false
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			if regular {
+				return nil, 0, fmt.Errorf("pseudo header after regular header")
+			}
+			switch f.Name {
+			case ":method", ":scheme", ":authority", ":path":
+			default:
+				return nil, 0, fmt.Errorf("invalid pseudo header '%s'", f.Name)
+			}
+			if _, dup := pseudo[f.Name]; dup {
+				return nil, 0, fmt.Errorf("duplicate pseudo header '%s'", f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+
+		regular = true
+		if f.Name != strings.ToLower(f.Name) {
+			return nil, 0, fmt.Errorf("uppercase header name '%s'", f.Name)
+		}
+		if connectionHeaders[f.Name] || (f.Name == "te" && f.Value != "trailers") {
+			return nil, 0, fmt.Errorf("connection specific header '%s'", f.Name)
+		}
+		//repeated names are joined once at the end, joining them one field
+		//at a time is quadratic
+		if _, seen := values[f.Name]; !seen {
+			names = append(names, f.Name)
+		}
+		values[f.Name] = append(values[f.Name], f.Value)
+	}
+	for _, name := range names {
+		sep := ", "
+		if name == "cookie" {
+			sep = "; "
+		}
+		req.Headers.ForceSet(name, strings.Join(values[name], sep))
+	}
+
+	method := pseudo[":method"]
+	if method == "" {
+		return nil, 0, fmt.Errorf("missing :method")
+	}
+	req.RequestLine.Method = method
+	if method == "CONNECT" {
+		if pseudo[":authority"] == "" || pseudo[":path"] != "" || pseudo[":scheme"] != "" {
+			return nil, 0, fmt.Errorf("invalid CONNECT pseudo headers")
+		}
+		req.RequestLine.RequestTarget = pseudo[":authority"]
+	} else {
+		path := pseudo[":path"]
+		if pseudo[":scheme"] == "" || path == "" {
+			return nil, 0, fmt.Errorf("missing :scheme or :path")
+		}
+		if !strings.HasPrefix(path, "/") && !(method == "OPTIONS" && path == "*") {
+			return nil, 0, fmt.Errorf("invalid :path '%s'", path)
+		}
+		req.RequestLine.RequestTarget = path
+	}
+
+	if authority := pseudo[":authority"]; authority != "" {
+		if _, ok := req.Headers.Get("host"); !ok {
+			req.Headers.Set("host", authority)
+		}
+	}
+
+	contentLength := -1
+	if val, ok := req.Headers.Get("content-length"); ok {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return nil, 0, fmt.Errorf("invalid content-length '%s'", val)
+		}
+		contentLength = n
+	}
+	return req, contentLength, nil
+}
+
+func (sc *serverConn) processData(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+
+	//the whole frame, padding included, counts against the windows
+	//(RFC 9113 section 6.9.1)
+	n := int64(len(f.Payload))
+	if n > sc.recvWindow {
+		return ConnError{ErrCodeFlowControl, "DATA past the connection window"}
+	}
+	sc.recvWindow -= n
+
+	//frames are consumed as they arrive, what bounds memory are the stream
+	//windows, so the connection window is replenished for every DATA
+	//frame, even ones for streams we are about to reset, once half of it
+	//is used
+	if sc.recvWindow <= defaultWindowSize/2 {
+		err := sc.windowUpdate(0, uint32(defaultWindowSize-sc.recvWindow))
+		if err != nil {
+			return err
+		}
+		sc.recvWindow = defaultWindowSize
+	}
+
+	sc.mu.Lock()
+	st, ok := sc.streams[f.StreamID]
+	sc.mu.Unlock()
+	if !ok || st.state != stateOpen {
+		if f.StreamID > sc.lastStreamID {
+			return ConnError{ErrCodeProtocol, "DATA on idle stream"}
+		}
+		return StreamError{f.StreamID, ErrCodeStreamClosed, "DATA on closed stream"}
+	}
+	if n > st.recvWindow {
+		return StreamError{f.StreamID, ErrCodeFlowControl, "DATA past the stream window"}
+	}
+	st.recvWindow -= n
+	if st.refused {
+		return nil
+	}
+
+	data, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	st.req.Body = append(st.req.Body, data...)
+	if st.contentLength >= 0 && len(st.req.Body) > st.contentLength {
+		return StreamError{f.StreamID, ErrCodeProtocol, "body longer than content-length"}
+	}
+	if len(st.req.Body) > request.MaxBodyBytes {
+		sc.refuseBody(st)
+		return nil
+	}
+
+	if f.Has(FlagEndStream) {
+		return sc.endRequest(st)
+	}
+
+	//the body is buffered until the handler runs, so the stream window only
+	//ever grants up to maxBodySize in total, topped up once half of it is
+	//used
+	target := min(defaultWindowSize, maxBodySize-int64(len(st.req.Body)))
+	if st.recvWindow <= target/2 {
+		increment := target - st.recvWindow
+		st.recvWindow = target
+		return sc.windowUpdate(f.StreamID, uint32(increment))
+	}
+	return nil
+}
+
+// refuseBody answers a request whose body is past request.MaxBodyBytes with
+// 413, without waiting for the rest of it.
+func (sc *serverConn) refuseBody(st *stream) {
+	st.refused = true
+	st.req.Body = nil
+	sc.startHandler(st, func(w *response.Writer, req *request.Request) {
+		body := []byte("request body too large\n")
+		w.WriteStatusLine(response.StatusContentTooLarge)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+}
+
+// endRequest runs the handler once the client has sent the whole request.
+func (sc *serverConn) endRequest(st *stream) error {
+	if st.contentLength >= 0 && len(st.req.Body) != st.contentLength {
+		return StreamError{st.id, ErrCodeProtocol, "body length does not match content-length"}
+	}
+	sc.mu.Lock()
+	st.state = stateHalfClosedRemote
+	sc.mu.Unlock()
+	sc.startHandler(st, sc.handler)
+	return nil
+}
+
+func (sc *serverConn) processSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.Has(FlagAck) {
+		if len(f.Payload) != 0 {
+			return ConnError{ErrCodeFrameSize, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+
+	settings, err := parseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		err = sc.applySetting(s)
+		if err != nil {
+			return err
+		}
+	}
+	return sc.writeFrame(&Frame{Type: FrameSettings, Flags: FlagAck})
+}
+
+func (sc *serverConn) applySetting(s Setting) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	switch s.ID {
+	case SettingEnablePush:
+		if s.Value > 1 {
+			return ConnError{ErrCodeProtocol, "invalid ENABLE_PUSH"}
+		}
+	case SettingInitialWindowSize:
+		if s.Value > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "INITIAL_WINDOW_SIZE too large"}
+		}
+		//the change applies to the windows of every open stream
+		delta := int64(s.Value) - sc.peerInitialWindow
+		sc.peerInitialWindow = int64(s.Value)
+		for _, st := range sc.streams {
+			st.sendWindow += delta
+			if st.sendWindow > maxWindowSize {
+				return ConnError{ErrCodeFlowControl, "stream window too large"}
+			}
+		}
+		sc.cond.Broadcast()
+	case SettingMaxFrameSize:
+		if s.Value < defaultMaxFrameSize || s.Value > maxAllowedFrameSize {
+			return ConnError{ErrCodeProtocol, "invalid MAX_FRAME_SIZE"}
+		}
+		sc.peerMaxFrameSize = s.Value
+	}
+	//our encoder never uses the dynamic table, so HEADER_TABLE_SIZE needs
+	//no action, and unknown settings are ignored
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f *Frame) error {
+	if len(f.Payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "WINDOW_UPDATE must be 4 bytes"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.Payload) & 0x7FFFFFFF)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.StreamID == 0 {
+		if increment == 0 {
+			return ConnError{ErrCodeProtocol, "zero window increment"}
+		}
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "connection window too large"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	st, ok := sc.streams[f.StreamID]
+	if !ok {
+		if f.StreamID > sc.lastStreamID {
+			return ConnError{ErrCodeProtocol, "WINDOW_UPDATE on idle stream"}
+		}
+		return nil
+	}
+	if increment == 0 {
+		return StreamError{f.StreamID, ErrCodeProtocol, "zero window increment"}
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return StreamError{f.StreamID, ErrCodeFlowControl, "stream window too large"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *Frame) error {
+	if len(f.Payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "RST_STREAM must be 4 bytes"}
+	}
+	if f.StreamID == 0 || f.StreamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on idle stream"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if st, ok := sc.streams[f.StreamID]; ok {
+		st.reset = true
+		if st.state == stateOpen {
+			delete(sc.streams, f.StreamID)
+		}
+		sc.cond.Broadcast()
+	}
+	return nil
+}
+
+func (sc *serverConn) processPing(f *Frame) error {
+	if len(f.Payload) != 8 {
+		return ConnError{ErrCodeFrameSize, "PING must be 8 bytes"}
+	}
+	if f.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "PING on a stream"}
+	}
+	if f.Has(FlagAck) {
+		return nil
+	}
+	return sc.writeFrame(&Frame{Type: FramePing, Flags: FlagAck, Payload: f.Payload})
+}
+
+func (sc *serverConn) startHandler(st *stream, handler Handler) {
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+
+		t := &streamTransport{sc: sc, st: st, noBody: st.req.RequestLine.Method == "HEAD"}
+		w := response.NewTransportWriter(t)
+		w.SetRequestMethod(st.req.RequestLine.Method)
+		handler(w, st.req)
+
+		if !t.headersSent {
+			sc.resetStream(st.id, ErrCodeInternal)
+		} else if !t.ended {
+			t.Finish(nil)
+		}
+		if st.refused {
+			//the client can stop sending the body (RFC 9113 section 8.1)
+			sc.resetStream(st.id, ErrCodeNo)
+		}
+
+		sc.mu.Lock()
+		delete(sc.streams, st.id)
+		drained := sc.goingAway && len(sc.streams) == 0
+		sc.mu.Unlock()
+		if drained {
+			//wakes the read loop up to end the connection
+			sc.conn.Close()
+		}
+	}()
+}
+
+func (sc *serverConn) resetStream(id uint32, code ErrCode) {
+	sc.mu.Lock()
+	st, ok := sc.streams[id]
+	if ok {
+		st.reset = true
+		if st.state == stateOpen {
+			delete(sc.streams, id)
+		}
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+
+	sc.writeFrame(&Frame{
+		Type:     FrameRSTStream,
+		StreamID: id,
+		Payload:  binary.BigEndian.AppendUint32(nil, uint32(code)),
+	})
+}
+
+func (sc *serverConn) goAway(code ErrCode) {
+	payload := binary.BigEndian.AppendUint32(nil, sc.lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	sc.writeFrame(&Frame{Type: FrameGoAway, Payload: payload})
+}
+
+func (sc *serverConn) windowUpdate(id uint32, increment uint32) error {
+	return sc.writeFrame(&Frame{
+		Type:     FrameWindowUpdate,
+		StreamID: id,
+		Payload:  binary.BigEndian.AppendUint32(nil, increment),
+	})
+}
+
+func (sc *serverConn) writeFrame(f *Frame) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return WriteFrame(sc.conn, f)
+}
+
+// writeHeaderBlock encodes and sends fields as HEADERS followed by as many
+// CONTINUATION frames as the peer's max frame size requires.
+func (sc *serverConn) writeHeaderBlock(id uint32, fields []HeaderField, endStream bool) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	sc.mu.Lock()
+	maxFrame := int(sc.peerMaxFrameSize)
+	sc.mu.Unlock()
+
+	block := sc.encoder.Encode(fields)
+	frameType := FrameHeaders
+	for {
+		chunk := block
+		if len(chunk) > maxFrame {
+			chunk = block[:maxFrame]
+		}
+		block = block[len(chunk):]
+
+		var flags uint8
+		if frameType == FrameHeaders && endStream {
+			flags |= FlagEndStream
+		}
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+		err := WriteFrame(sc.conn, &Frame{Type: frameType, Flags: flags, StreamID: id, Payload: chunk})
+		if err != nil || len(block) == 0 {
+			return err
+		}
+		frameType = FrameContinuation
+	}
+}
+
+// streamTransport sends a handler's response as frames on its stream.
+type streamTransport struct {
+	sc          *serverConn
+	st          *stream
+	noBody      bool
+	headersSent bool
+	ended       bool
+}
+
+func (t *streamTransport) WriteHeader(statusCode response.StatusCode, h headers.Headers) error {
+	if statusCode == response.StatusNoContent || statusCode == response.StatusNotModified {
+		t.noBody = true
+	}
+	fields := []HeaderField{{":status", strconv.Itoa(int(statusCode))}}
+	fields = append(fields, headerFields(h)...)
+
+	t.headersSent = true
+	t.ended = t.noBody
+	return t.sc.writeHeaderBlock(t.st.id, fields, t.noBody)
+}
+
+func (t *streamTransport) WriteBody(p []byte) (int, error) {
+	if t.noBody {
+		return len(p), nil
+	}
+	if t.ended {
+		return 0, fmt.Errorf("http2: stream %d already ended", t.st.id)
+	}
+
+	sc := t.sc
+	written := 0
+	for written < len(p) {
+		sc.mu.Lock()
+		for !t.st.reset && !sc.closed && (sc.sendWindow <= 0 || t.st.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+		if t.st.reset || sc.closed {
+			sc.mu.Unlock()
+			return written, fmt.Errorf("http2: stream %d closed", t.st.id)
+		}
+		n := int64(len(p) - written)
+		n = min(n, sc.sendWindow, t.st.sendWindow, int64(sc.peerMaxFrameSize))
+		sc.sendWindow -= n
+		t.st.sendWindow -= n
+		sc.mu.Unlock()
+
+		err := sc.writeFrame(&Frame{Type: FrameData, StreamID: t.st.id, Payload: p[written : written+int(n)]})
+		if err != nil {
+			return written, err
+		}
+		written += int(n)
+	}
+	return written, nil
+}
+
+func (t *streamTransport) Finish(trailers headers.Headers) error {
+	if t.ended {
+		return nil
+	}
+	t.ended = true
+	if len(trailers) > 0 {
+		return t.sc.writeHeaderBlock(t.st.id, headerFields(trailers), true)
+	}
+	return t.sc.writeFrame(&Frame{Type: FrameData, Flags: FlagEndStream, StreamID: t.st.id})
+}
+
+// Abort resets the stream, which tells the client the response is incomplete
+// where ending it would not.
+func (t *streamTransport) Abort() error {
+	t.ended = true
+	t.sc.resetStream(t.st.id, ErrCodeInternal)
+	return nil
+}
+
+// headerFields converts h to lowercase fields in a stable order, dropping
+// the headers HTTP/2 does not allow.
+func headerFields(h headers.Headers) []HeaderField {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := []HeaderField{}
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if connectionHeaders[lower] || lower == "trailer" {
+			continue
+		}
+		for _, value := range h.Values(name) {
+			fields = append(fields, HeaderField{lower, value})
+		}
+	}
+	return fields
+}
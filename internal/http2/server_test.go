@@ -0,0 +1,290 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// startServer serves HTTP/2 with prior knowledge on every connection, for the
+// tests speaking raw frames. servertest can't be used here, as the server
+// package imports this one; client_test.go uses it for everything else.
+func startServer(t *testing.T, handler Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ServeConn(conn, conn, handler)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func writeText(w *response.Writer, status response.StatusCode, body string) {
+	w.WriteStatusLine(status)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+// rawClient speaks HTTP/2 frame by frame, for behavior net/http won't
+// exercise.
+type rawClient struct {
+	t    *testing.T
+	conn net.Conn
+	enc  Encoder
+	dec  *Decoder
+}
+
+func dialRaw(t *testing.T, addr string, settings ...Setting) *rawClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c := &rawClient{t: t, conn: conn, dec: NewDecoder(4096)}
+	_, err = conn.Write([]byte(ClientPreface))
+	require.NoError(t, err)
+	c.write(&Frame{Type: FrameSettings, Payload: settingsPayload(settings)})
+	return c
+}
+
+func (c *rawClient) write(f *Frame) {
+	require.NoError(c.t, WriteFrame(c.conn, f))
+}
+
+func (c *rawClient) request(id uint32, fields []HeaderField, endStream bool) {
+	flags := FlagEndHeaders
+	if endStream {
+		flags |= FlagEndStream
+	}
+	c.write(&Frame{Type: FrameHeaders, Flags: flags, StreamID: id, Payload: c.enc.Encode(fields)})
+}
+
+// next returns the next frame that isn't connection housekeeping.
+func (c *rawClient) next() *Frame {
+	for {
+		f, err := ReadFrame(c.conn, maxAllowedFrameSize)
+		require.NoError(c.t, err)
+		if f.Type == FrameSettings || f.Type == FrameWindowUpdate {
+			continue
+		}
+		return f
+	}
+}
+
+func (c *rawClient) headers(f *Frame) []HeaderField {
+	require.Equal(c.t, FrameHeaders, f.Type)
+	fields, err := c.dec.Decode(f.Payload)
+	require.NoError(c.t, err)
+	return fields
+}
+
+func getFields(path string) []HeaderField {
+	return []HeaderField{{":method", "GET"}, {":scheme", "http"}, {":path", path}, {":authority", "test"}}
+}
+
+func TestRawFrames(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		writeText(w, response.StatusOK, strings.Repeat("x", 100))
+	})
+
+	// Test: PING is acknowledged with the same payload
+	c := dialRaw(t, addr)
+	c.write(&Frame{Type: FramePing, Payload: []byte("12345678")})
+	f := c.next()
+	assert.Equal(t, FramePing, f.Type)
+	assert.True(t, f.Has(FlagAck))
+	assert.Equal(t, []byte("12345678"), f.Payload)
+
+	// Test: HEAD ends the stream with the headers
+	c.request(1, []HeaderField{{":method", "HEAD"}, {":scheme", "http"}, {":path", "/"}, {":authority", "test"}}, true)
+	f = c.next()
+	assert.True(t, f.Has(FlagEndStream))
+	fields := c.headers(f)
+	assert.Equal(t, HeaderField{":status", "200"}, fields[0])
+	assert.Contains(t, fields, HeaderField{"content-length", "100"})
+
+	// Test: Uppercase header names reset the stream
+	c.request(3, append(getFields("/"), HeaderField{"X-Upper", "1"}), true)
+	f = c.next()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(3), f.StreamID)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+
+	// Test: Even stream ids are a connection error
+	c.request(4, getFields("/"), true)
+	f = c.next()
+	assert.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+}
+
+func TestFlowControl(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		writeText(w, response.StatusOK, strings.Repeat("x", 100))
+	})
+
+	// Test: Nothing is sent past a zero window until it is opened
+	c := dialRaw(t, addr, Setting{SettingInitialWindowSize, 0})
+	c.request(1, getFields("/"), true)
+	f := c.next()
+	assert.Equal(t, HeaderField{":status", "200"}, c.headers(f)[0])
+
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := ReadFrame(c.conn, maxAllowedFrameSize)
+	require.Error(t, err)
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	c.write(&Frame{Type: FrameWindowUpdate, StreamID: 1, Payload: binary.BigEndian.AppendUint32(nil, 60)})
+	f = c.next()
+	assert.Equal(t, FrameData, f.Type)
+	assert.Len(t, f.Payload, 60)
+
+	c.write(&Frame{Type: FrameWindowUpdate, StreamID: 1, Payload: binary.BigEndian.AppendUint32(nil, 40)})
+	f = c.next()
+	assert.Len(t, f.Payload, 40)
+	f = c.next()
+	assert.Equal(t, FrameData, f.Type)
+	assert.True(t, f.Has(FlagEndStream))
+}
+
+func TestRequestLimits(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		writeText(w, response.StatusOK, fmt.Sprintf("%d", len(req.Body)))
+	})
+
+	// Test: The header list size is advertised
+	c := dialRaw(t, addr)
+	f, err := ReadFrame(c.conn, maxAllowedFrameSize)
+	require.NoError(t, err)
+	settings, err := parseSettings(f.Payload)
+	require.NoError(t, err)
+	assert.Contains(t, settings, Setting{SettingMaxHeaderListSize, request.MaxHeaderBytes})
+
+	// Test: A header list past it resets the stream, and the table entry it
+	// added is still usable
+	value := strings.Repeat("v", 1000)
+	block := append([]byte{0x40, 0x01, 'x', 0x7f, 0xe9, 0x06}, value...)
+	block = append(block, bytes.Repeat([]byte{0xbe}, 100)...)
+	c.write(&Frame{Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream, StreamID: 1, Payload: append(c.enc.Encode(getFields("/")), block...)})
+	f = c.next()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, ErrCodeEnhanceYourCalm, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+
+	c.write(&Frame{Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream, StreamID: 3, Payload: append(c.enc.Encode(getFields("/")), 0xbe)})
+	f = c.next()
+	assert.Equal(t, HeaderField{":status", "200"}, c.headers(f)[0])
+	for !f.Has(FlagEndStream) {
+		f = c.next()
+	}
+
+	// Test: A stream depending on itself is reset after its block is decoded
+	priority := []byte{0, 0, 0, 5, 16}
+	block = append(priority, c.enc.Encode(getFields("/"))...)
+	block = append(block, 0x40, 0x01, 'y', 0x01, 'z')
+	c.write(&Frame{Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream | FlagPriority, StreamID: 5, Payload: block})
+	f = c.next()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+
+	c.write(&Frame{Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream, StreamID: 7, Payload: append(c.enc.Encode(getFields("/")), 0xbe)})
+	f = c.next()
+	assert.Equal(t, HeaderField{":status", "200"}, c.headers(f)[0])
+	for !f.Has(FlagEndStream) {
+		f = c.next()
+	}
+
+	post := []HeaderField{{":method", "POST"}, {":scheme", "http"}, {":path", "/"}, {":authority", "test"}}
+
+	// Test: A declared body past request.MaxBodyBytes is answered with 413
+	c.request(9, append(post, HeaderField{"content-length", fmt.Sprint(request.MaxBodyBytes + 1)}), false)
+	f = c.next()
+	assert.Equal(t, HeaderField{":status", "413"}, c.headers(f)[0])
+	for f.Type != FrameRSTStream {
+		f = c.next()
+	}
+	assert.Equal(t, ErrCodeNo, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+
+}
+
+func TestGoAwayDrainsStreams(t *testing.T) {
+	done := make(chan struct{})
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		defer close(done)
+		writeText(w, response.StatusOK, strings.Repeat("x", defaultWindowSize+100))
+	})
+
+	// Test: A handler blocked on flow control finishes after GOAWAY once the
+	// window opens, and the connection ends with the last stream
+	c := dialRaw(t, addr)
+	c.request(1, getFields("/"), true)
+	assert.Equal(t, HeaderField{":status", "200"}, c.headers(c.next())[0])
+	received := 0
+	for received < defaultWindowSize {
+		f := c.next()
+		require.Equal(t, FrameData, f.Type)
+		received += len(f.Payload)
+	}
+	c.write(&Frame{Type: FrameGoAway, Payload: make([]byte, 8)})
+	c.write(&Frame{Type: FrameWindowUpdate, Payload: binary.BigEndian.AppendUint32(nil, 1000)})
+	c.write(&Frame{Type: FrameWindowUpdate, StreamID: 1, Payload: binary.BigEndian.AppendUint32(nil, 1000)})
+	for {
+		f := c.next()
+		received += len(f.Payload)
+		if f.Has(FlagEndStream) {
+			break
+		}
+	}
+	assert.Equal(t, defaultWindowSize+100, received)
+	_, err := ReadFrame(c.conn, maxAllowedFrameSize)
+	assert.ErrorIs(t, err, io.EOF)
+	<-done
+
+	// Test: A handler blocked on flow control is released when the client
+	// hangs up after GOAWAY
+	done = make(chan struct{})
+	c = dialRaw(t, addr)
+	c.request(1, getFields("/"), true)
+	c.next()
+	c.write(&Frame{Type: FrameGoAway, Payload: make([]byte, 8)})
+	c.conn.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler still blocked after the client went away")
+	}
+}
+
+func TestHeadLength(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		hdrs := headers.NewHeaders()
+		hdrs.Set("Content-Type", "text/plain")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(hdrs)
+		w.WriteBody([]byte(strings.Repeat("x", 100)))
+	})
+
+	// Test: HEAD gets the Content-Length its GET body would have had
+	c := dialRaw(t, addr)
+	c.request(1, []HeaderField{{":method", "HEAD"}, {":scheme", "http"}, {":path", "/"}, {":authority", "test"}}, true)
+	f := c.next()
+	assert.True(t, f.Has(FlagEndStream))
+	assert.Contains(t, c.headers(f), HeaderField{"content-length", "100"})
+}
@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	LeastConnections
+	ConsistentHash
+)
+
+const virtualNodes = 100
+
+func ParseStrategy(s string) (Strategy, error) {
+	switch s {
+	case "round-robin":
+		return RoundRobin, nil
+	case "least-connections":
+		return LeastConnections, nil
+	case "consistent-hash":
+		return ConsistentHash, nil
+	default:
+		return RoundRobin, fmt.Errorf("unknown load balancing strategy: '%s'", s)
+	}
+}
+
+// Backend is a single upstream in a LoadBalancer pool.
+type Backend struct {
+	URL *url.URL
+
+	healthy      atomic.Bool
+	active       atomic.Int64
+	failures     atomic.Int32
+	ejectedUntil atomic.Int64
+}
+
+// Available reports whether the backend passes health checks and is not
+// currently ejected because of failed requests.
+func (b *Backend) Available() bool {
+	return b.healthy.Load() && time.Now().UnixNano() >= b.ejectedUntil.Load()
+}
+
+func (b *Backend) ActiveConnections() int64 {
+	return b.active.Load()
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend int
+}
+
+// LoadBalancer is a reverse proxy that spreads requests over a pool of
+// backends. Idempotent requests that fail before a response is received are
+// retried on another backend, and backends that keep failing are ejected for
+// EjectDuration.
+type LoadBalancer struct {
+	Backends []*Backend
+	Strategy Strategy
+	// Proxy holds the forwarding settings shared by all backends; its
+	// Upstream is not used.
+	Proxy *ReverseProxy
+	// HashKey picks the key used by ConsistentHash, the client ip by default.
+	HashKey func(req *request.Request) string
+
+	MaxRetries    int
+	MaxFailures   int
+	EjectDuration time.Duration
+
+	HealthCheckPath    string
+	HealthCheckTimeout time.Duration
+
+	next atomic.Uint64
+	ring []ringPoint
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewLoadBalancer(upstreams []string, strategy Strategy) (*LoadBalancer, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("load balancer needs at least one upstream")
+	}
+
+	lb := &LoadBalancer{
+		Strategy:           strategy,
+		Proxy:              &ReverseProxy{Client: NewClient()},
+		HashKey:            clientIP,
+		MaxRetries:         2,
+		MaxFailures:        3,
+		EjectDuration:      30 * time.Second,
+		HealthCheckPath:    "/",
+		HealthCheckTimeout: 2 * time.Second,
+		stop:               make(chan struct{}),
+	}
+
+	for i, upstream := range upstreams {
+		p, err := NewReverseProxy(upstream)
+		if err != nil {
+			return nil, err
+		}
+		b := &Backend{URL: p.Upstream}
+		b.healthy.Store(true)
+		lb.Backends = append(lb.Backends, b)
+
+		for v := 0; v < virtualNodes; v++ {
+			lb.ring = append(lb.ring, ringPoint{
+				hash:    hashString(upstream + "#" + strconv.Itoa(v)),
+				backend: i,
+			})
+		}
+	}
+	sort.Slice(lb.ring, func(i, j int) bool { return lb.ring[i].hash < lb.ring[j].hash })
+
+	return lb, nil
+}
+
+func (lb *LoadBalancer) Handler(w *response.Writer, req *request.Request) {
+	attempts := 1
+	if isIdempotent(req.RequestLine.Method) {
+		attempts += lb.MaxRetries
+	}
+
+	tried := make(map[*Backend]bool)
+	for i := 0; i < attempts; i++ {
+		b := lb.pick(req, tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+
+		b.active.Add(1)
+		resp, err := lb.Proxy.roundTrip(req, b.URL)
+		if err != nil {
+			b.active.Add(-1)
+			log.Printf("error proxying to backend '%s': %v", b.URL, err)
+			lb.recordFailure(b)
+			continue
+		}
+
+		if resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout {
+			lb.recordFailure(b)
+		} else {
+			b.failures.Store(0)
+		}
+
+		err = writeResponse(w, req, resp)
+		resp.Body.Close()
+		b.active.Add(-1)
+		if err != nil {
+			log.Printf("error relaying backend response: %v", err)
+		}
+		return
+	}
+
+	if len(tried) == 0 {
+		writeStatus(w, response.StatusServiceUnavailable)
+		return
+	}
+	writeStatus(w, response.StatusBadGateway)
+}
+
+// pick chooses the next available backend that has not been tried yet, or nil
+// when there is none.
+func (lb *LoadBalancer) pick(req *request.Request, tried map[*Backend]bool) *Backend {
+	usable := func(b *Backend) bool {
+		return !tried[b] && b.Available()
+	}
+
+	switch lb.Strategy {
+	case LeastConnections:
+		var best *Backend
+		for _, b := range lb.Backends {
+			if usable(b) && (best == nil || b.active.Load() < best.active.Load()) {
+				best = b
+			}
+		}
+		return best
+	case ConsistentHash:
+		h := hashString(lb.HashKey(req))
+		start := sort.Search(len(lb.ring), func(i int) bool { return lb.ring[i].hash >= h })
+		for i := 0; i < len(lb.ring); i++ {
+			b := lb.Backends[lb.ring[(start+i)%len(lb.ring)].backend]
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	default:
+		n := uint64(len(lb.Backends))
+		for i := uint64(0); i < n; i++ {
+			b := lb.Backends[(lb.next.Add(1)-1)%n]
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	}
+}
+
+func (lb *LoadBalancer) recordFailure(b *Backend) {
+	if lb.MaxFailures <= 0 {
+		return
+	}
+	if int(b.failures.Add(1)) >= lb.MaxFailures {
+		b.failures.Store(0)
+		b.ejectedUntil.Store(time.Now().Add(lb.EjectDuration).UnixNano())
+		log.Printf("ejecting backend '%s' for %v", b.URL, lb.EjectDuration)
+	}
+}
+
+// StartHealthChecks probes every backend at HealthCheckPath once per interval
+// until Close is called. Backends answering with a 5xx or not answering at all
+// are taken out of rotation until a probe succeeds again.
+func (lb *LoadBalancer) StartHealthChecks(interval time.Duration) {
+	client := NewClient()
+	client.Timeout = lb.HealthCheckTimeout
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			lb.checkHealth(client)
+			select {
+			case <-lb.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (lb *LoadBalancer) checkHealth(client *http.Client) {
+	var wg sync.WaitGroup
+	for _, b := range lb.Backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			u := *b.URL
+			u.Path = strings.TrimSuffix(b.URL.Path, "/") + lb.HealthCheckPath
+			healthy := false
+			resp, err := client.Get(u.String())
+			if err == nil {
+				resp.Body.Close()
+				healthy = resp.StatusCode < 500
+			}
+			if b.healthy.Swap(healthy) != healthy {
+				log.Printf("backend '%s' healthy: %v", b.URL, healthy)
+			}
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (lb *LoadBalancer) Close() {
+	lb.stopOnce.Do(func() { close(lb.stop) })
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+func clientIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func hashString(s string) uint32 {
+	return crc32.ChecksumIEEE([]byte(s))
+}
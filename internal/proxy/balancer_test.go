@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+// namedBackend answers every request with its name, and with a 503 on
+// /health once unhealthy is set.
+func namedBackend(name string, unhealthy *bool, mu *sync.Mutex) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		status := response.StatusOK
+		if req.RequestLine.RequestTarget == "/health" && unhealthy != nil {
+			mu.Lock()
+			if *unhealthy {
+				status = response.StatusServiceUnavailable
+			}
+			mu.Unlock()
+		}
+		body := []byte(name)
+		w.WriteStatusLine(status)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func TestLoadBalancerRoundRobin(t *testing.T) {
+	upstreams := []string{
+		servertest.Start(t, namedBackend("a", nil, nil)),
+		servertest.Start(t, namedBackend("b", nil, nil)),
+		servertest.Start(t, namedBackend("c", nil, nil)),
+	}
+	lb, err := NewLoadBalancer(upstreams, RoundRobin)
+	require.NoError(t, err)
+	proxyURL := servertest.Start(t, lb.Handler)
+
+	seen := []string{}
+	for i := 0; i < 6; i++ {
+		_, body := servertest.Get(t, nil, proxyURL+"/")
+		seen = append(seen, body)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, seen)
+}
+
+func TestLoadBalancerConsistentHash(t *testing.T) {
+	upstreams := []string{
+		servertest.Start(t, namedBackend("a", nil, nil)),
+		servertest.Start(t, namedBackend("b", nil, nil)),
+		servertest.Start(t, namedBackend("c", nil, nil)),
+	}
+	lb, err := NewLoadBalancer(upstreams, ConsistentHash)
+	require.NoError(t, err)
+	lb.HashKey = func(req *request.Request) string {
+		return req.RequestLine.RequestTarget
+	}
+	proxyURL := servertest.Start(t, lb.Handler)
+
+	// Test: The same key always lands on the same backend
+	_, first := servertest.Get(t, nil, proxyURL+"/users/42")
+	for i := 0; i < 5; i++ {
+		_, body := servertest.Get(t, nil, proxyURL+"/users/42")
+		assert.Equal(t, first, body)
+	}
+
+	// Test: Keys are spread over more than one backend
+	seen := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		_, body := servertest.Get(t, nil, fmt.Sprintf("%s/items/%d", proxyURL, i))
+		seen[body] = true
+	}
+	assert.Greater(t, len(seen), 1)
+}
+
+func TestLoadBalancerLeastConnections(t *testing.T) {
+	upstreams := []string{
+		servertest.Start(t, namedBackend("a", nil, nil)),
+		servertest.Start(t, namedBackend("b", nil, nil)),
+	}
+	lb, err := NewLoadBalancer(upstreams, LeastConnections)
+	require.NoError(t, err)
+
+	// Test: The backend with fewer in-flight requests is preferred
+	lb.Backends[0].active.Add(1)
+	proxyURL := servertest.Start(t, lb.Handler)
+	_, body := servertest.Get(t, nil, proxyURL+"/")
+	assert.Equal(t, "b", body)
+	assert.Equal(t, int64(0), lb.Backends[1].ActiveConnections())
+}
+
+func TestLoadBalancerRetryAndEjection(t *testing.T) {
+	dead := servertest.Start(t, namedBackend("dead", nil, nil))
+	alive := servertest.Start(t, namedBackend("alive", nil, nil))
+
+	lb, err := NewLoadBalancer([]string{dead, alive}, RoundRobin)
+	require.NoError(t, err)
+	lb.MaxFailures = 1
+	proxyURL := servertest.Start(t, lb.Handler)
+
+	// stop the first backend so connecting to it fails
+	s, err := server.Serve(0, nil)
+	require.NoError(t, err)
+	s.Close()
+	lb.Backends[0].URL.Host = s.Listener.Addr().String()
+
+	// Test: Idempotent requests are retried on another backend
+	resp, body := servertest.Get(t, nil, proxyURL+"/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "alive", body)
+	assert.False(t, lb.Backends[0].Available())
+
+	// Test: Ejected backends are skipped entirely
+	for i := 0; i < 3; i++ {
+		_, body = servertest.Get(t, nil, proxyURL+"/")
+		assert.Equal(t, "alive", body)
+	}
+
+	// Test: Non-idempotent requests are not retried
+	lb.Backends[0].ejectedUntil.Store(0)
+	lb.next.Store(0)
+	resp, err = http.Post(proxyURL+"/", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 502, resp.StatusCode)
+
+	// Test: No available backends
+	lb.Backends[1].healthy.Store(false)
+	resp, _ = servertest.Get(t, nil, proxyURL+"/")
+	assert.Equal(t, 503, resp.StatusCode)
+}
+
+func TestLoadBalancerHealthChecks(t *testing.T) {
+	var mu sync.Mutex
+	unhealthy := false
+	upstreams := []string{
+		servertest.Start(t, namedBackend("a", &unhealthy, &mu)),
+		servertest.Start(t, namedBackend("b", nil, nil)),
+	}
+	lb, err := NewLoadBalancer(upstreams, RoundRobin)
+	require.NoError(t, err)
+	lb.HealthCheckPath = "/health"
+	lb.StartHealthChecks(10 * time.Millisecond)
+	defer lb.Close()
+
+	mu.Lock()
+	unhealthy = true
+	mu.Unlock()
+	require.Eventually(t, func() bool { return !lb.Backends[0].Available() }, time.Second, 5*time.Millisecond)
+
+	mu.Lock()
+	unhealthy = false
+	mu.Unlock()
+	require.Eventually(t, func() bool { return lb.Backends[0].Available() }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// ErrForbiddenDestination is returned when dialing an address the forward
+// proxy may not reach.
+var ErrForbiddenDestination = errors.New("destination is not a public address")
+
+// ForwardProxy lets clients use the server as an HTTP proxy. Absolute-form
+// requests ("GET http://host/path") are forwarded to the host in the target,
+// and CONNECT requests open a raw TCP tunnel to the requested authority.
+//
+// Neither reaches loopback, private or link-local addresses unless
+// AllowPrivate is set, so the proxy can't be used to get at internal
+// services. The check runs on the resolved address, names pointing inside
+// are refused too.
+type ForwardProxy struct {
+	// AllowedPorts lists the destination ports CONNECT may tunnel to.
+	AllowedPorts map[int]bool
+	AllowPrivate bool
+	DialTimeout  time.Duration
+	proxy        *ReverseProxy
+}
+
+func NewForwardProxy(allowedPorts ...int) *ForwardProxy {
+	ports := make(map[int]bool)
+	for _, port := range allowedPorts {
+		ports[port] = true
+	}
+	f := &ForwardProxy{
+		AllowedPorts: ports,
+		DialTimeout:  10 * time.Second,
+	}
+	client := NewClient()
+	client.Transport.(*http.Transport).DialContext = f.dial
+	f.proxy = &ReverseProxy{Client: client}
+	return f
+}
+
+func (f *ForwardProxy) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	d := net.Dialer{Timeout: f.DialTimeout, Control: f.checkDestination}
+	return d.DialContext(ctx, network, address)
+}
+
+// checkDestination runs for every address a dial tries, after the name was
+// resolved.
+func (f *ForwardProxy) checkDestination(network, address string, _ syscall.RawConn) error {
+	if f.AllowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !isPublic(addrPort.Addr()) {
+		return ErrForbiddenDestination
+	}
+	return nil
+}
+
+// cgnat is the shared address space carriers use behind their NATs.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnat.Contains(ip))
+}
+
+// IsProxyRequest reports whether req is meant for a forward proxy rather than
+// for this server itself.
+func IsProxyRequest(req *request.Request) bool {
+	return req.RequestLine.Method == "CONNECT" || req.IsAbsoluteForm()
+}
+
+func (f *ForwardProxy) Handler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "CONNECT" {
+		f.tunnel(w, req)
+		return
+	}
+
+	u, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || !req.IsAbsoluteForm() || u.Host == "" {
+		writeStatus(w, response.StatusBadRequest)
+		return
+	}
+
+	//forward the path as origin-form to the host named in the target
+	outReq := *req
+	outReq.RequestLine.RequestTarget = u.RequestURI()
+	upstream := &url.URL{Scheme: u.Scheme, Host: u.Host}
+
+	resp, err := f.proxy.roundTrip(&outReq, upstream)
+	if errors.Is(err, ErrForbiddenDestination) {
+		writeStatus(w, response.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("error forwarding to '%s': %v", upstream, err)
+		writeStatus(w, response.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	err = writeResponse(w, req, resp)
+	if err != nil {
+		log.Printf("error relaying forwarded response: %v", err)
+	}
+}
+
+func (f *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	authority := req.RequestLine.RequestTarget
+	_, portStr, err := net.SplitHostPort(authority)
+	if err != nil {
+		writeStatus(w, response.StatusBadRequest)
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || !f.AllowedPorts[port] {
+		writeStatus(w, response.StatusForbidden)
+		return
+	}
+
+	upstream, err := f.dial(context.Background(), "tcp", authority)
+	if errors.Is(err, ErrForbiddenDestination) {
+		writeStatus(w, response.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("error dialing tunnel destination '%s': %v", authority, err)
+		writeStatus(w, response.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		log.Printf("error hijacking connection: %v", err)
+		writeStatus(w, response.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	if err != nil {
+		return
+	}
+	//the client may have started talking before seeing our response
+	if len(buffered) > 0 {
+		_, err = upstream.Write(buffered)
+		if err != nil {
+			return
+		}
+	}
+	splice(conn, upstream)
+}
+
+// splice copies bytes in both directions until both sides are done, half
+// closing each side once its peer has stopped sending.
+func splice(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(a, b)
+		closeWrite(a)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(b, a)
+		closeWrite(b)
+	}()
+	wg.Wait()
+}
+
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
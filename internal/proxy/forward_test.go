@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+// startEchoServer accepts tcp connections and writes back everything it reads.
+func startEchoServer(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func connect(t *testing.T, proxyURL, authority string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	u, err := url.Parse(proxyURL)
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", authority, authority)
+	reader := bufio.NewReader(conn)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	return conn, reader, statusLine
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	echoPort := startEchoServer(t)
+	f := NewForwardProxy(echoPort)
+	f.AllowPrivate = true
+	proxyURL := servertest.Start(t, f.Handler)
+
+	// Test: Bytes are tunneled to the destination and back
+	conn, reader, statusLine := connect(t, proxyURL, fmt.Sprintf("127.0.0.1:%d", echoPort))
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", statusLine)
+	blank, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+
+	_, err = conn.Write([]byte("ping over the tunnel"))
+	require.NoError(t, err)
+	buf := make([]byte, len("ping over the tunnel"))
+	_, err = io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping over the tunnel", string(buf))
+
+	// Test: Closing our side ends the tunnel
+	conn.(*net.TCPConn).CloseWrite()
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: Ports outside the allowlist are refused
+	_, _, statusLine = connect(t, proxyURL, "127.0.0.1:25")
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine)
+
+	// Test: Internal addresses are refused unless allowed, by name too
+	f.AllowPrivate = false
+	_, _, statusLine = connect(t, proxyURL, fmt.Sprintf("127.0.0.1:%d", echoPort))
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine)
+	_, _, statusLine = connect(t, proxyURL, fmt.Sprintf("localhost:%d", echoPort))
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine)
+}
+
+func TestForwardProxyAbsoluteForm(t *testing.T) {
+	upstream := servertest.Start(t, echoUpstream)
+	f := NewForwardProxy()
+	f.AllowPrivate = true
+	proxyURL := servertest.Start(t, f.Handler)
+
+	u, err := url.Parse(proxyURL)
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+
+	resp, err := client.Get(upstream + "/coffee?size=large")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, string(body), "GET /coffee?size=large\n")
+	assert.Contains(t, string(body), "x-forwarded-for=127.0.0.1\n")
+
+	// Test: Internal addresses are refused unless allowed
+	f.AllowPrivate = false
+	resp, err = client.Get(upstream + "/coffee")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestIsPublic(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fd00::1", "fe80::1", "::ffff:127.0.0.1"} {
+		assert.False(t, isPublic(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{"93.184.216.34", "2606:4700::1111"} {
+		assert.True(t, isPublic(netip.MustParseAddr(addr)), addr)
+	}
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+const bufferSize = 32 * 1024
+
+// hopByHopHeaders only apply to a single connection and must not be forwarded
+// by a proxy (RFC 9110 section 7.6.1).
+var hopByHopHeaders = []string{
+	"connection",
+	"keep-alive",
+	"proxy-connection",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// ReverseProxy forwards requests to a single upstream server and relays the
+// upstream response back to the client.
+type ReverseProxy struct {
+	Upstream *url.URL
+	// StripPrefix is removed from the request target before it is joined
+	// with the upstream path.
+	StripPrefix string
+	// PreserveHost forwards the client's Host header instead of the
+	// upstream's host.
+	PreserveHost bool
+	Client       *http.Client
+}
+
+func NewReverseProxy(upstream string) (*ReverseProxy, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported upstream scheme: '%s'", u.Scheme)
+	}
+
+	return &ReverseProxy{
+		Upstream: u,
+		Client:   NewClient(),
+	}, nil
+}
+
+// NewClient returns a client that leaves redirects and content codings to the
+// downstream client instead of handling them itself.
+func NewClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:              nil,
+			DisableCompression: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (p *ReverseProxy) Handler(w *response.Writer, req *request.Request) {
+	resp, err := p.roundTrip(req, p.Upstream)
+	if err != nil {
+		log.Printf("error proxying to upstream '%s': %v", p.Upstream, err)
+		writeStatus(w, response.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	err = writeResponse(w, req, resp)
+	if err != nil {
+		log.Printf("error relaying upstream response: %v", err)
+	}
+}
+
+func (p *ReverseProxy) roundTrip(req *request.Request, upstream *url.URL) (*http.Response, error) {
+	outReq, err := p.newUpstreamRequest(req, upstream)
+	if err != nil {
+		return nil, err
+	}
+	return p.Client.Do(outReq)
+}
+
+func (p *ReverseProxy) newUpstreamRequest(req *request.Request, upstream *url.URL) (*http.Request, error) {
+	target := strings.TrimPrefix(req.RequestLine.RequestTarget, p.StripPrefix)
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+	path, query, _ := strings.Cut(target, "?")
+
+	u := *upstream
+	u.Path = strings.TrimSuffix(upstream.Path, "/") + path
+	u.RawPath = ""
+	u.RawQuery = query
+
+	var body io.Reader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	outReq, err := http.NewRequest(req.RequestLine.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	hdrs := forwardHeaders(req.Headers)
+	for key, val := range hdrs {
+		if key == "host" || key == "content-length" {
+			continue
+		}
+		outReq.Header.Set(key, val)
+	}
+
+	host, _ := req.Headers.Get("host")
+	if p.PreserveHost && host != "" {
+		outReq.Host = host
+	}
+	addForwardedHeaders(outReq.Header, req, host)
+
+	return outReq, nil
+}
+
+// forwardHeaders copies h without the hop-by-hop headers, including any that
+// are nominated by the Connection header.
+func forwardHeaders(h headers.Headers) headers.Headers {
+	out := headers.NewHeaders()
+	for key, val := range h {
+		out[key] = val
+	}
+
+	if conn, ok := out.Get("connection"); ok {
+		for _, name := range strings.Split(conn, ",") {
+			out.ForceRemoveHeader(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		out.ForceRemoveHeader(name)
+	}
+	return out
+}
+
+func addForwardedHeaders(h http.Header, req *request.Request, host string) {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return
+	}
+
+	if prior := h.Get("X-Forwarded-For"); prior != "" {
+		h.Set("X-Forwarded-For", prior+", "+clientIP)
+	} else {
+		h.Set("X-Forwarded-For", clientIP)
+	}
+
+	//ipv6 addresses have to be quoted and bracketed in Forwarded
+	forNode := clientIP
+	if strings.Contains(clientIP, ":") {
+		forNode = fmt.Sprintf("\"[%s]\"", clientIP)
+	}
+	element := fmt.Sprintf("for=%s;proto=http", forNode)
+	if host != "" {
+		element += fmt.Sprintf(";host=\"%s\"", host)
+	}
+	if prior := h.Get("Forwarded"); prior != "" {
+		element = prior + ", " + element
+	}
+	h.Set("Forwarded", element)
+}
+
+// writeResponse relays the upstream status line and headers and streams the
+// body to the client with chunked encoding, followed by any upstream trailers.
+func writeResponse(w *response.Writer, req *request.Request, resp *http.Response) error {
+	hdrs := headers.NewHeaders()
+	for key, vals := range resp.Header {
+		for _, val := range vals {
+			hdrs.Set(key, val)
+		}
+	}
+	hdrs = forwardHeaders(hdrs)
+	hdrs.Set("Connection", "close")
+
+	err := w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+	if err != nil {
+		return err
+	}
+
+	if !hasBody(req, resp) {
+		return w.WriteHeaders(hdrs)
+	}
+
+	hdrs.ForceRemoveHeader("Content-Length")
+	hdrs.Set("Transfer-Encoding", "chunked")
+	if len(resp.Trailer) > 0 {
+		names := make([]string, 0, len(resp.Trailer))
+		for key := range resp.Trailer {
+			names = append(names, key)
+		}
+		hdrs.Set("Trailer", strings.Join(names, ", "))
+	}
+	err = w.WriteHeaders(hdrs)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, bufferSize)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			_, err = w.WriteChunkedBody(buf[:n])
+			if err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			//the last chunk would pass the truncated body off as complete
+			w.Abort()
+			return readErr
+		}
+	}
+
+	_, err = w.WriteChunkedbodyDone()
+	if err != nil {
+		return err
+	}
+
+	trailers := headers.NewHeaders()
+	for key, vals := range resp.Trailer {
+		trailers.Set(key, strings.Join(vals, ", "))
+	}
+	return w.WriteTrailers(trailers)
+}
+
+func hasBody(req *request.Request, resp *http.Response) bool {
+	if req.RequestLine.Method == "HEAD" {
+		return false
+	}
+	code := resp.StatusCode
+	return code >= 200 && code != 204 && code != 304
+}
+
+func writeStatus(w *response.Writer, status response.StatusCode) {
+	body := []byte(fmt.Sprintf("%s\n", response.ReasonPhrase(status)))
+	w.WriteStatusLine(status)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+// echoUpstream answers with a description of the request it received.
+func echoUpstream(w *response.Writer, req *request.Request) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s\n", req.RequestLine.Method, req.RequestLine.RequestTarget)
+	for _, key := range []string{"host", "x-custom", "x-forwarded-for", "forwarded", "keep-alive", "x-drop"} {
+		if val, ok := req.Headers.Get(key); ok {
+			fmt.Fprintf(&sb, "%s=%s\n", key, val)
+		}
+	}
+	fmt.Fprintf(&sb, "body=%s\n", req.Body)
+
+	body := []byte(sb.String())
+	status := response.StatusOK
+	if strings.HasSuffix(req.RequestLine.RequestTarget, "/missing") {
+		status = response.StatusNotFound
+	}
+	hdrs := response.GetDefaultHeaders(len(body))
+	hdrs.Set("X-Upstream", "echo")
+	hdrs.Set("Keep-Alive", "timeout=5")
+	hdrs.Set("Set-Cookie", "a=1; Expires=Wed, 01 May 2024 12:00:00 GMT")
+	hdrs.Set("Set-Cookie", "b=2")
+	w.WriteStatusLine(status)
+	w.WriteHeaders(hdrs)
+	w.WriteBody(body)
+}
+
+func TestReverseProxy(t *testing.T) {
+	upstream := servertest.Start(t, echoUpstream)
+
+	p, err := NewReverseProxy(upstream + "/base")
+	require.NoError(t, err)
+	p.StripPrefix = "/api"
+	proxyURL := servertest.Start(t, p.Handler)
+
+	// Test: Method, headers and body are forwarded
+	req, err := http.NewRequest("POST", proxyURL+"/api/items?id=7", strings.NewReader("payload"))
+	require.NoError(t, err)
+	req.Header.Set("X-Custom", "yes")
+	req.Header.Set("X-Drop", "secret")
+	req.Header.Set("Connection", "X-Drop")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "echo", resp.Header.Get("X-Upstream"))
+	assert.Empty(t, resp.Header.Get("Keep-Alive"))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 01 May 2024 12:00:00 GMT", "b=2"}, resp.Header.Values("Set-Cookie"))
+	assert.Contains(t, string(body), "POST /base/items?id=7\n")
+	assert.Contains(t, string(body), "x-custom=yes\n")
+	assert.Contains(t, string(body), "x-forwarded-for=127.0.0.1\n")
+	assert.Contains(t, string(body), "forwarded=for=127.0.0.1;proto=http;host=")
+	assert.Contains(t, string(body), "body=payload\n")
+	assert.NotContains(t, string(body), "x-drop")
+	assert.NotContains(t, string(body), "keep-alive")
+
+	// Test: Upstream status is relayed
+	resp, err = http.Get(proxyURL + "/api/missing")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: HEAD responses have no body
+	resp, err = http.Head(proxyURL + "/api/items")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestReverseProxyUpstreamDown(t *testing.T) {
+	p, err := NewReverseProxy("http://127.0.0.1:1")
+	require.NoError(t, err)
+	proxyURL := servertest.Start(t, p.Handler)
+
+	resp, err := http.Get(proxyURL + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 502, resp.StatusCode)
+
+	_, err = NewReverseProxy("ftp://example.com")
+	require.Error(t, err)
+}
+
+func TestReverseProxyTruncatedUpstream(t *testing.T) {
+	upstream := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(100))
+		w.Write([]byte("only part"))
+		w.Flush()
+	})
+	p, err := NewReverseProxy(upstream)
+	require.NoError(t, err)
+	proxyURL := servertest.Start(t, p.Handler)
+
+	// Test: A body cut short upstream reaches the client cut short, not as a
+	// complete chunked body
+	resp, err := http.Get(proxyURL + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "only part", string(body))
+}
@@ -0,0 +1,74 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxRequestLineBytes caps the request line. Longer ones are answered
+	// with 414, since it's the target that makes them long.
+	MaxRequestLineBytes = 8 << 10
+	// MaxHeaderBytes caps the header section, trailers included.
+	MaxHeaderBytes = 64 << 10
+	// MaxBodyBytes caps the body, which is read into memory before the
+	// handler runs. Larger bodies are answered with 413, a declared
+	// Content-Length before any of the body is read.
+	MaxBodyBytes = 32 << 20
+
+	maxChunkSizeLineBytes = 4 << 10
+)
+
+// Parse failures, wrapped in a *ParseError telling where they happened.
+var (
+	ErrMalformedRequestLine = errors.New("malformed request line")
+	ErrInvalidMethod        = errors.New("invalid method")
+	ErrInvalidTarget        = errors.New("invalid request target")
+	ErrURITooLong           = errors.New("request target too long")
+	ErrUnsupportedVersion   = errors.New("unsupported http version")
+	ErrMalformedHeader      = errors.New("malformed header field")
+	ErrHeaderTooLarge       = errors.New("header section too large")
+	ErrInvalidContentLength = errors.New("invalid content-length")
+	ErrInvalidFraming       = errors.New("invalid message framing")
+	ErrBodyTooLarge         = errors.New("request body too large")
+	ErrMalformedChunk       = errors.New("malformed chunked body")
+	ErrIncompleteRequest    = errors.New("incomplete request")
+	ErrTrailingData         = errors.New("data after the end of the request")
+)
+
+// ParseError is a request that could not be parsed. Offset is the byte of
+// the request where the problem was found and Status the code to answer
+// with: 413, 414, 431 and 505 for the matching errors, 400 for the rest. The
+// message only names what was wrong, so it can be shown to clients.
+type ParseError struct {
+	Err    error
+	Offset int
+	Status int
+}
+
+func newParseError(err error, offset int) *ParseError {
+	status := 400
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		status = 413
+	case errors.Is(err, ErrURITooLong):
+		status = 414
+	case errors.Is(err, ErrHeaderTooLarge):
+		status = 431
+	case errors.Is(err, ErrUnsupportedVersion):
+		status = 505
+	}
+	return &ParseError{Err: err, Offset: offset, Status: status}
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) HTTPStatus() int {
+	return e.Status
+}
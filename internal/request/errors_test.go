@@ -0,0 +1,66 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		err    error
+		offset int
+		status int
+	}{
+		{"too many parts", "GET / HTTP/1.1 x\r\n\r\n", ErrMalformedRequestLine, 0, 400},
+		{"lowercase method", "GeT / HTTP/1.1\r\n\r\n", ErrInvalidMethod, 1, 400},
+		{"relative target", "GET index.html HTTP/1.1\r\n\r\n", ErrInvalidTarget, 4, 400},
+		{"old version", "GET / HTTP/1.0\r\n\r\n", ErrUnsupportedVersion, 6, 505},
+		{"garbage version", "GET / HTTX/1.1\r\n\r\n", ErrMalformedRequestLine, 6, 400},
+		{"long target", "GET /" + strings.Repeat("a", MaxRequestLineBytes) + " HTTP/1.1\r\n\r\n", ErrURITooLong, MaxRequestLineBytes, 414},
+		{"bad header", "GET / HTTP/1.1\r\nHost: a\r\nBroken\r\n\r\n", ErrMalformedHeader, 25, 400},
+		{"large headers", "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", MaxHeaderBytes) + "\r\n\r\n", ErrHeaderTooLarge, 16, 431},
+		{"bad content-length", "POST / HTTP/1.1\r\nContent-Length: ten\r\n\r\n", ErrInvalidContentLength, 40, 400},
+		{"large body", "POST / HTTP/1.1\r\nContent-Length: 33554433\r\n\r\n", ErrBodyTooLarge, 45, 413},
+		{"large chunks", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n1000000\r\n" + strings.Repeat("a", 1<<24) + "\r\n1000001\r\n", ErrBodyTooLarge, 47 + 9 + 1<<24 + 2, 413},
+		{"non final chunked", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked, gzip\r\n\r\n", ErrInvalidFraming, 53, 400},
+		{"unknown coding", "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\nContent-Length: 3\r\n\r\nabc", ErrInvalidFraming, 63, 400},
+		{"both framings", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n0\r\n\r\n", ErrInvalidFraming, 66, 400},
+		{"bad chunk size", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", ErrMalformedChunk, 47, 400},
+		{"truncated", "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc", ErrIncompleteRequest, 42, 400},
+		{"trailing data", "GET / HTTP/1.1\r\n\r\nGET", ErrTrailingData, 18, 400},
+	}
+	for _, tt := range tests {
+		_, err := RequestFromReader(&chunkReader{data: tt.data, numBytesPerRead: 7})
+
+		// Test: Parse errors match their sentinel and say where and how to
+		// answer them
+		var parseErr *ParseError
+		require.True(t, errors.As(err, &parseErr), tt.name)
+		assert.ErrorIs(t, err, tt.err, tt.name)
+		assert.Equal(t, tt.offset, parseErr.Offset, tt.name)
+		assert.Equal(t, tt.status, parseErr.HTTPStatus(), tt.name)
+	}
+
+	// Test: A connection closed before the request is just EOF
+	_, _, err := ReadRequest(&chunkReader{data: ""})
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Read errors are returned as such, not as parse errors
+	_, _, err = ReadRequest(failingReader{})
+	var parseErr *ParseError
+	assert.False(t, errors.As(err, &parseErr))
+	assert.ErrorContains(t, err, "connection reset")
+}
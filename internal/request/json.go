@@ -0,0 +1,106 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// DefaultMaxJSONBytes caps JSON bodies when DecodeJSON is given no limit.
+const DefaultMaxJSONBytes = 1 << 20
+
+// JSONError tells why a body could not be decoded. Status is the status code
+// to answer with: 415 for a body that isn't JSON, 413 for one over the limit
+// and 400 for anything wrong with the JSON itself. Message is safe to show to
+// clients.
+type JSONError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *JSONError) Error() string {
+	return e.Message
+}
+
+func (e *JSONError) HTTPStatus() int {
+	return e.Status
+}
+
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeJSON decodes the body of r into v. The body has to be declared as
+// application/json (or a +json type) in UTF-8, be at most maxBytes long, hold
+// a single JSON value and only use fields v has. Failures are *JSONError.
+func DecodeJSON(r *Request, v any, maxBytes int) error {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxJSONBytes
+	}
+
+	contentType, _ := r.Headers.Get("content-type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !(mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return &JSONError{Status: 415, Message: "Content-Type must be application/json", Err: err}
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return &JSONError{Status: 415, Message: "JSON must be encoded as UTF-8"}
+	}
+	if len(r.Body) > maxBytes {
+		return &JSONError{Status: 413, Message: fmt.Sprintf("request body must not be larger than %d bytes", maxBytes)}
+	}
+	if len(bytes.TrimSpace(r.Body)) == 0 {
+		return &JSONError{Status: 400, Message: "request body must not be empty"}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(r.Body))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err != nil {
+		return &JSONError{Status: 400, Message: jsonErrorMessage(err), Err: err}
+	}
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return &JSONError{Status: 400, Message: "request body must only contain a single JSON value"}
+	}
+	return nil
+}
+
+// jsonErrorMessage describes a decoding error without Go type names or
+// other details of the handler.
+func jsonErrorMessage(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON"
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			return fmt.Sprintf("field '%s' must be %s", typeErr.Field, jsonKind(typeErr.Type.Kind().String()))
+		}
+		return fmt.Sprintf("body must be %s", jsonKind(typeErr.Type.Kind().String()))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Sprintf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return "invalid JSON body"
+}
+
+func jsonKind(kind string) string {
+	switch {
+	case kind == "string":
+		return "a string"
+	case kind == "bool":
+		return "a boolean"
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "slice" || kind == "array":
+		return "an array"
+	}
+	return "an object"
+}
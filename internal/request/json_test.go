@@ -0,0 +1,62 @@
+package request
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+)
+
+type createUser struct {
+	Name  string   `json:"name"`
+	Age   int      `json:"age"`
+	Admin bool     `json:"admin"`
+	Tags  []string `json:"tags"`
+}
+
+func jsonRequest(contentType, body string) *Request {
+	r := &Request{Headers: headers.NewHeaders(), Body: []byte(body)}
+	if contentType != "" {
+		r.Headers.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	// Test: Valid bodies decode, +json types and a UTF-8 charset included
+	var u createUser
+	require.NoError(t, DecodeJSON(jsonRequest("application/json", `{"name":"ada","age":36,"tags":["x"]}`), &u, 0))
+	assert.Equal(t, createUser{Name: "ada", Age: 36, Tags: []string{"x"}}, u)
+	require.NoError(t, DecodeJSON(jsonRequest("application/vnd.api+json; charset=UTF-8", `{"name":"bob"}`), &u, 0))
+	assert.Equal(t, "bob", u.Name)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		message     string
+	}{
+		{"no content type", "", `{}`, 415, "Content-Type must be application/json"},
+		{"form content type", "application/x-www-form-urlencoded", `{}`, 415, "Content-Type must be application/json"},
+		{"other charset", "application/json; charset=latin1", `{}`, 415, "JSON must be encoded as UTF-8"},
+		{"too large", "application/json", `{"name":"` + strings.Repeat("x", 100) + `"}`, 413, "request body must not be larger than 64 bytes"},
+		{"empty", "application/json", "  ", 400, "request body must not be empty"},
+		{"syntax", "application/json", `{"name": "ada",}`, 400, "malformed JSON at offset 16"},
+		{"truncated", "application/json", `{"name": "ada"`, 400, "malformed JSON"},
+		{"wrong type", "application/json", `{"age": "old"}`, 400, "field 'age' must be a number"},
+		{"not an object", "application/json", `[1, 2]`, 400, "body must be an object"},
+		{"unknown field", "application/json", `{"name": "ada", "role": "root"}`, 400, `unknown field "role"`},
+		{"trailing data", "application/json", `{"name": "ada"} {"name": "bob"}`, 400, "request body must only contain a single JSON value"},
+	}
+	for _, tt := range tests {
+		err := DecodeJSON(jsonRequest(tt.contentType, tt.body), &createUser{}, 64)
+		var jsonErr *JSONError
+		require.True(t, errors.As(err, &jsonErr), tt.name)
+		assert.Equal(t, tt.status, jsonErr.Status, tt.name)
+		assert.Equal(t, tt.message, jsonErr.Message, tt.name)
+	}
+}
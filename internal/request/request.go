@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 
@@ -20,6 +21,9 @@ const (
 	StateInit RequstState = iota
 	StateParsingHeaders
 	StateParsingBody
+	StateParsingChunkSize
+	StateParsingChunkData
+	StateParsingTrailers
 	StateDone
 )
 
@@ -27,7 +31,15 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
+	Trailers    headers.Headers
 	State       RequstState
+	RemoteAddr  string
+
+	chunkRemaining int
+	//offset is how many bytes of the request were parsed, headerBytes how
+	//many of them belong to the header section and trailers
+	offset      int
+	headerBytes int
 }
 
 type RequestLine struct {
@@ -38,9 +50,10 @@ type RequestLine struct {
 
 func NewRequest() *Request {
 	return &Request{
-		State:   StateInit,
-		Headers: headers.NewHeaders(),
-		Body: []byte{},
+		State:    StateInit,
+		Headers:  headers.NewHeaders(),
+		Body:     []byte{},
+		Trailers: headers.NewHeaders(),
 	}
 }
 
@@ -49,6 +62,24 @@ func (r *Request) isDone() bool {
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	req, rest, err := ReadRequest(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, newParseError(ErrTrailingData, req.offset)
+	}
+	return req, nil
+}
+
+// ReadRequest parses a single request from reader. Bytes that were read past
+// the end of the request, like a pipelined request or the first bytes of an
+// upgraded protocol, are returned instead of being treated as an error.
+//
+// Requests that can't be parsed fail with a *ParseError. A connection closed
+// before the first byte gives io.EOF, and errors reading from reader are
+// returned wrapped.
+func ReadRequest(reader io.Reader) (*Request, []byte, error) {
 	p := make([]byte, bufferSize)
 	req := NewRequest()
 
@@ -64,18 +95,18 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		bytesRead, err := reader.Read(p[index:])
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				if req.State != StateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", req.State, bytesRead)
+				if req.offset+index == 0 {
+					return nil, nil, io.EOF
 				}
-				break
+				return nil, nil, newParseError(ErrIncompleteRequest, req.offset+index)
 			}
-			return nil, fmt.Errorf("error reading from connection")
+			return nil, nil, fmt.Errorf("reading request: %w", err)
 		}
 		//log.Printf("infinite loop starts here: %v", 70)
 		index += bytesRead
 		bytesParsed, err := req.parse(p[:index])
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		//log.Printf("infinite loop starts here: %v", 76)
 
@@ -84,13 +115,8 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		//log.Printf("infinite loop starts here: %v", 80)
 
 	}
-	// val, _ := req.Headers.Get("content-length")
-	// valInt, _ := strconv.Atoi(val)
-	// if len(req.Body) < valInt {
-	// 	return nil, fmt.Errorf("body smaller than content-length")
-	// }
 
-	return req, nil
+	return req, p[:index], nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -102,6 +128,7 @@ func (r *Request) parse(data []byte) (int, error) {
 			return 0, err
 		}
 		totalBytesParsed += n
+		r.offset += n
 		if n == 0 {
 			break
 		}
@@ -117,13 +144,16 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 		if n == 0 {
+			if len(data) > MaxRequestLineBytes {
+				return 0, newParseError(ErrURITooLong, r.offset+MaxRequestLineBytes)
+			}
 			return 0, nil
 		}
 		r.RequestLine = *reqLine
 		r.State = StateParsingHeaders
 		return n, nil
 	case StateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.parseFields(r.Headers, data)
 		if err != nil {
 			return 0, err
 		}
@@ -134,21 +164,34 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 
 		return n, nil
 	case StateParsingBody:
+		//a body whose length the two headers disagree on, or can't tell, has
+		//to be refused rather than guessed (RFC 9112 section 6.3)
+		if _, ok := r.Headers.Get("transfer-encoding"); ok {
+			if !r.IsChunked() {
+				return 0, newParseError(fmt.Errorf("%w: chunked is not the final transfer coding", ErrInvalidFraming), r.offset)
+			}
+			if _, ok := r.Headers.Get("content-length"); ok {
+				return 0, newParseError(fmt.Errorf("%w: both transfer-encoding and content-length", ErrInvalidFraming), r.offset)
+			}
+			r.State = StateParsingChunkSize
+			return r.parseSingle(data)
+		}
 		//log.Printf("data before going to the body: '%s'", data)
 		val, exists := r.Headers.Get("content-length")
 		//log.Printf("value: '%s'", val)
 		if !exists {
-			if len(data) != 0 {
-				//
-				return 0, fmt.Errorf("data to be parsed: '%s' and content-legnth header: '%v'", data, r.Headers["content-length"])
-			}
+			//without a content-length there is no body, anything left over
+			//belongs to whatever follows the request
 			r.State = StateDone
 			return 0, nil
 		}
 		valInInteger, err := strconv.Atoi(val)
 		//log.Printf("value in integer: %v", valInInteger)
-		if err != nil {
-			return 0, fmt.Errorf("error getting content-length")
+		if err != nil || valInInteger < 0 {
+			return 0, newParseError(ErrInvalidContentLength, r.offset)
+		}
+		if valInInteger > MaxBodyBytes {
+			return 0, newParseError(ErrBodyTooLarge, r.offset)
 		}
 		//log.Printf("data: '%s'", data)
 		if valInInteger == 0 {
@@ -156,15 +199,59 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, nil
 		}
 		//log.Printf("r.Body is: '%s'", r.Body)
-		if len(r.Body) > valInInteger {
-			return 0, fmt.Errorf("body length greater than content-length")
-		}
+		n := min(valInInteger-len(r.Body), len(data))
+		r.Body = append(r.Body, data[:n]...)
 		if len(r.Body) == valInInteger {
 			r.State = StateDone
-			return len(data), nil
 		}
-		r.Body = append(r.Body, data...)
-		return len(data), nil
+		return n, nil
+	case StateParsingChunkSize:
+		indx := bytes.Index(data, []byte(crlf))
+		if indx == -1 {
+			if len(data) > maxChunkSizeLineBytes {
+				return 0, newParseError(fmt.Errorf("%w: chunk size line too long", ErrMalformedChunk), r.offset)
+			}
+			return 0, nil
+		}
+		size, err := parseChunkSize(data[:indx])
+		if err != nil {
+			return 0, newParseError(fmt.Errorf("%w: %v", ErrMalformedChunk, err), r.offset)
+		}
+		if size > MaxBodyBytes-len(r.Body) {
+			return 0, newParseError(ErrBodyTooLarge, r.offset)
+		}
+		if size == 0 {
+			r.State = StateParsingTrailers
+		} else {
+			r.chunkRemaining = size
+			r.State = StateParsingChunkData
+		}
+		return indx + len(crlf), nil
+	case StateParsingChunkData:
+		if r.chunkRemaining > 0 {
+			n := min(r.chunkRemaining, len(data))
+			r.Body = append(r.Body, data[:n]...)
+			r.chunkRemaining -= n
+			return n, nil
+		}
+		//every chunk's data is followed by a crlf
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, newParseError(fmt.Errorf("%w: chunk data not terminated by crlf", ErrMalformedChunk), r.offset)
+		}
+		r.State = StateParsingChunkSize
+		return len(crlf), nil
+	case StateParsingTrailers:
+		n, done, err := r.parseFields(r.Trailers, data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.State = StateDone
+		}
+		return n, nil
 	case StateDone:
 		return 0, fmt.Errorf("trying to parse in done state")
 	default:
@@ -172,11 +259,76 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+// parseFields parses a header or trailer line into h, keeping the whole
+// header section under MaxHeaderBytes.
+func (r *Request) parseFields(h headers.Headers, data []byte) (int, bool, error) {
+	n, done, err := h.Parse(data)
+	if err != nil {
+		return 0, false, newParseError(fmt.Errorf("%w: %v", ErrMalformedHeader, err), r.offset)
+	}
+	if n == 0 && r.headerBytes+len(data) > MaxHeaderBytes {
+		return 0, false, newParseError(ErrHeaderTooLarge, r.offset)
+	}
+	r.headerBytes += n
+	if r.headerBytes > MaxHeaderBytes {
+		return 0, false, newParseError(ErrHeaderTooLarge, r.offset)
+	}
+	return n, done, nil
+}
+
+// IsChunked reports whether the request body uses the chunked transfer coding,
+// which has to be the last coding listed in Transfer-Encoding.
+func (r *Request) IsChunked() bool {
+	_, ok := r.Headers.Get("transfer-encoding")
+	return ok && r.Headers.IsChunked()
+}
+
+// validTarget accepts the origin-form ("/path"), absolute-form used with
+// forward proxies ("http://host/path"), authority-form only for CONNECT
+// ("host:port") and asterisk-form only for OPTIONS ("*").
+func validTarget(method, target string) bool {
+	if method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		return err == nil && host != "" && port != ""
+	}
+	if method == "OPTIONS" && target == "*" {
+		return true
+	}
+	if strings.HasPrefix(target, "/") {
+		return true
+	}
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// IsAbsoluteForm reports whether the request target is a full url, as sent
+// by clients talking to a forward proxy.
+func (r *Request) IsAbsoluteForm() bool {
+	target := r.RequestLine.RequestTarget
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+func parseChunkSize(line []byte) (int, error) {
+	//chunk extensions are allowed after a ';' and are ignored
+	sizeStr, _, _ := bytes.Cut(line, []byte(";"))
+	sizeStr = bytes.TrimSpace(sizeStr)
+	if len(sizeStr) == 0 {
+		return 0, fmt.Errorf("empty chunk size")
+	}
+	size, err := strconv.ParseInt(string(sizeStr), 16, 32)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid chunk size: '%s'", sizeStr)
+	}
+	return int(size), nil
+}
+
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
 	indx := bytes.Index(data, []byte(crlf))
 	if indx == -1 {
 		return nil, 0, nil
 	}
+	if indx > MaxRequestLineBytes {
+		return nil, 0, newParseError(ErrURITooLong, MaxRequestLineBytes)
+	}
 
 	reqLine, err := requestLineParsing(data[:indx])
 	if err != nil {
@@ -186,41 +338,57 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 
 }
 
+// requestLineParsing parses the request line, which is always at the start of
+// the request, so offsets into data are offsets into the request
 func requestLineParsing(data []byte) (*RequestLine, error) {
 
 	httpParts := strings.Split(string(data), " ")
 
 	//checking for valid no of parts
 	if len(httpParts) != 3 {
-		return nil, fmt.Errorf("invalid no of request line parts")
+		return nil, newParseError(ErrMalformedRequestLine, 0)
 	}
+	method, target, version := httpParts[0], httpParts[1], httpParts[2]
+	targetOffset := len(method) + 1
+	versionOffset := targetOffset + len(target) + 1
 
 	//checking if method token contains all capitals
-	for _, c := range httpParts[0] {
+	if method == "" {
+		return nil, newParseError(ErrInvalidMethod, 0)
+	}
+	for i, c := range method {
 		if c < 'A' || c > 'Z' {
-			return nil, fmt.Errorf("method token is invalid")
+			return nil, newParseError(ErrInvalidMethod, i)
 		}
 	}
 
-	//checking if the path starts with a */*
-	if httpParts[1][0] != '/' {
-		return nil, fmt.Errorf("invalid target")
-	}
-
-	//checking the version
-	if httpParts[2] != "HTTP/1.1" {
-		return nil, fmt.Errorf("invalid http version")
+	//checking the target is in one of the forms allowed for the method
+	if !validTarget(method, target) {
+		return nil, newParseError(ErrInvalidTarget, targetOffset)
 	}
 
-	//parsing http version
-	verionParts := strings.Split(httpParts[2], "/")
-	if len(verionParts) != 2 {
-		return nil, fmt.Errorf("invalid version")
+	//checking the version, only HTTP/1.1 is served but any well formed
+	//version is a 505 rather than a malformed request
+	if version != "HTTP/1.1" {
+		if isHTTPVersion(version) {
+			return nil, newParseError(ErrUnsupportedVersion, versionOffset)
+		}
+		return nil, newParseError(ErrMalformedRequestLine, versionOffset)
 	}
 
 	return &RequestLine{
-		HttpVersion:   verionParts[1],
-		RequestTarget: httpParts[1],
-		Method:        httpParts[0],
+		HttpVersion:   strings.TrimPrefix(version, "HTTP/"),
+		RequestTarget: target,
+		Method:        method,
 	}, nil
 }
+
+// isHTTPVersion reports whether version has the HTTP-version syntax,
+// "HTTP/" DIGIT "." DIGIT
+func isHTTPVersion(version string) bool {
+	digits, ok := strings.CutPrefix(version, "HTTP/")
+	if !ok || len(digits) != 3 || digits[1] != '.' {
+		return false
+	}
+	return digits[0] >= '0' && digits[0] <= '9' && digits[2] >= '0' && digits[2] <= '9'
+}
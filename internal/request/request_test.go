@@ -1,6 +1,7 @@
 package request
 
 import (
+	"bytes"
 	"io"
 	"testing"
 
@@ -236,3 +237,187 @@ func TestBodyRequest(t *testing.T) {
 	_, err = RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+func TestChunkedBodyRequest(t *testing.T) {
+	// Test: Chunked body with trailers
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"7;ext=1\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than its size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"2\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestRequestWriteTo(t *testing.T) {
+	// Test: Round trip with a fixed-length body
+	req := NewRequest()
+	req.RequestLine = RequestLine{Method: "POST", RequestTarget: "/submit", HttpVersion: "1.1"}
+	req.Headers.Set("Host", "localhost:42069")
+	req.Body = []byte("hello world!\n")
+
+	buf := &bytes.Buffer{}
+	n, err := req.WriteTo(buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Contains(t, buf.String(), "content-length: 13\r\n")
+
+	r, err := RequestFromReader(&chunkReader{data: buf.String(), numBytesPerRead: 3})
+	require.NoError(t, err)
+	assert.Equal(t, req.RequestLine, r.RequestLine)
+	assert.Equal(t, "localhost:42069", r.Headers["host"])
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Round trip with a chunked body and trailers, dropping a length
+	// that would contradict the chunks
+	req = NewRequest()
+	req.RequestLine = RequestLine{Method: "PUT", RequestTarget: "/upload", HttpVersion: "1.1"}
+	req.Headers.Set("Transfer-Encoding", "chunked")
+	req.Headers.Set("Content-Length", "13")
+	req.Body = []byte("streamed data")
+	req.Trailers.Set("X-Content-Length", "13")
+
+	buf.Reset()
+	_, err = req.WriteTo(buf)
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "\r\ncontent-length")
+
+	r, err = RequestFromReader(&chunkReader{data: buf.String(), numBytesPerRead: 5})
+	require.NoError(t, err)
+	assert.Equal(t, "PUT", r.RequestLine.Method)
+	assert.Equal(t, "streamed data", string(r.Body))
+	assert.Equal(t, "13", r.Trailers["x-content-length"])
+
+	// Test: Round trip without a body
+	req = NewRequest()
+	req.RequestLine = RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"}
+	buf.Reset()
+	_, err = req.WriteTo(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", buf.String())
+
+	// Test: Mismatched content-length is rejected
+	req = NewRequest()
+	req.RequestLine = RequestLine{Method: "POST", RequestTarget: "/", HttpVersion: "1.1"}
+	req.Headers.Set("Content-Length", "3")
+	req.Body = []byte("too long")
+	_, err = req.WriteTo(&bytes.Buffer{})
+	require.Error(t, err)
+}
+
+func TestRequestTargetForms(t *testing.T) {
+	// Test: Absolute-form target
+	reader := &chunkReader{
+		data:            "GET http://example.com/coffee?x=1 HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/coffee?x=1", r.RequestLine.RequestTarget)
+	assert.True(t, r.IsAbsoluteForm())
+
+	// Test: Authority-form target for CONNECT
+	reader = &chunkReader{
+		data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+	assert.False(t, r.IsAbsoluteForm())
+
+	// Test: Authority-form target is only valid for CONNECT
+	reader = &chunkReader{
+		data:            "GET example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Asterisk-form target for OPTIONS
+	reader = &chunkReader{
+		data:            "OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "*", r.RequestLine.RequestTarget)
+
+	// Test: Empty target
+	reader = &chunkReader{
+		data:            "GET  HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestReadRequestLeftover(t *testing.T) {
+	// Test: Pipelined data after a request with a body is returned
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"helloGET / HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 64,
+	}
+	r, rest, err := ReadRequest(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	unread, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(rest)+string(unread))
+
+	// Test: Bytes following a request without a body are returned
+	reader = &chunkReader{
+		data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n\x16\x03\x01",
+		numBytesPerRead: 128,
+	}
+	r, rest, err = ReadRequest(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "\x16\x03\x01", string(rest))
+
+	// Test: RequestFromReader rejects data after the request
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello world",
+		numBytesPerRead: 64,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
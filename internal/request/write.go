@@ -0,0 +1,96 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+)
+
+// WriteTo serializes the request in HTTP/1.1 wire format so that it can be
+// read back with RequestFromReader. Chunked requests have their body written
+// as a single chunk followed by the trailers; otherwise a Content-Length header
+// is added when the body is not empty and the request does not declare one.
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+
+	version := r.RequestLine.HttpVersion
+	if version == "" {
+		version = "1.1"
+	}
+	fmt.Fprintf(cw, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, version)
+
+	hdrs := headers.NewHeaders()
+	for key, val := range r.Headers {
+		hdrs[key] = val
+	}
+
+	chunked := r.IsChunked()
+	if chunked {
+		//chunked framing makes a length meaningless, and both at once is
+		//a request servers have to refuse
+		hdrs.ForceRemoveHeader("content-length")
+	} else {
+		val, ok := hdrs.Get("content-length")
+		if ok {
+			length, err := strconv.Atoi(val)
+			if err != nil || length != len(r.Body) {
+				return cw.n, fmt.Errorf("content-length '%s' does not match body length %d", val, len(r.Body))
+			}
+		} else if len(r.Body) > 0 {
+			hdrs.ForceSet("content-length", strconv.Itoa(len(r.Body)))
+		}
+	}
+
+	writeFields(cw, hdrs)
+	cw.Write([]byte(crlf))
+
+	if !chunked {
+		cw.Write(r.Body)
+		return cw.n, cw.err
+	}
+
+	if len(r.Body) > 0 {
+		fmt.Fprintf(cw, "%x\r\n", len(r.Body))
+		cw.Write(r.Body)
+		cw.Write([]byte(crlf))
+	}
+	cw.Write([]byte("0\r\n"))
+	writeFields(cw, r.Trailers)
+	cw.Write([]byte(crlf))
+	return cw.n, cw.err
+}
+
+func writeFields(w io.Writer, h headers.Headers) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, val := range h.Values(key) {
+			fmt.Fprintf(w, "%s: %s\r\n", key, val)
+		}
+	}
+}
+
+// countWriter keeps track of the bytes written and remembers the first error,
+// after which every write is a no-op.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,189 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+)
+
+// TimeFormat is the IMF-fixdate format used for HTTP dates.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsoleteTimeFormats are the older date formats recipients still have to
+// accept (RFC 9110 section 5.6.7).
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+func FormatHTTPDate(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+func ParseHTTPDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	t, err := time.Parse(TimeFormat, s)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range obsoleteTimeFormats {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid HTTP date '%s'", s)
+}
+
+// StrongETag derives an entity tag from the body itself, so it changes
+// whenever a single byte does.
+func StrongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag derives an entity tag from a modification time and size, which is
+// cheap but can miss changes that keep both.
+func WeakETag(modTime time.Time, size int64) string {
+	return `W/"` + strconv.FormatInt(modTime.UnixNano(), 16) + "-" + strconv.FormatInt(size, 16) + `"`
+}
+
+// Validators describe the current representation of a resource. Either field
+// may be left empty.
+type Validators struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// SetHeaders adds the ETag and Last-Modified headers.
+func (v Validators) SetHeaders(h headers.Headers) {
+	if v.ETag != "" {
+		h.ForceSet("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.ForceSet("Last-Modified", FormatHTTPDate(v.LastModified))
+	}
+}
+
+// EvaluatePreconditions applies the conditional request headers in the order
+// of RFC 9110 section 13.2.2. It returns StatusOK when the request should be
+// served, or StatusNotModified or StatusPreconditionFailed otherwise.
+func EvaluatePreconditions(method string, reqHeaders headers.Headers, v Validators) StatusCode {
+	safe := method == "GET" || method == "HEAD"
+
+	if ifMatch, ok := reqHeaders.Get("if-match"); ok {
+		if !matchETag(ifMatch, v.ETag, true) {
+			return StatusPreconditionFailed
+		}
+	} else if ifUnmodifiedSince, ok := reqHeaders.Get("if-unmodified-since"); ok && !v.LastModified.IsZero() {
+		date, err := ParseHTTPDate(ifUnmodifiedSince)
+		if err == nil && v.LastModified.Truncate(time.Second).After(date) {
+			return StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch, ok := reqHeaders.Get("if-none-match"); ok {
+		if matchETag(ifNoneMatch, v.ETag, false) {
+			if safe {
+				return StatusNotModified
+			}
+			return StatusPreconditionFailed
+		}
+	} else if ifModifiedSince, ok := reqHeaders.Get("if-modified-since"); ok && safe && !v.LastModified.IsZero() {
+		date, err := ParseHTTPDate(ifModifiedSince)
+		if err == nil && !v.LastModified.Truncate(time.Second).After(date) {
+			return StatusNotModified
+		}
+	}
+	return StatusOK
+}
+
+// WritePrecondition sends the 304 or 412 response EvaluatePreconditions
+// asked for. A 304 carries the validators and no body.
+func WritePrecondition(w *Writer, status StatusCode, v Validators) error {
+	var body []byte
+	var hdrs headers.Headers
+	if status == StatusNotModified {
+		hdrs = headers.NewHeaders()
+		hdrs.Set("Connection", "close")
+		v.SetHeaders(hdrs)
+	} else {
+		body = []byte(ReasonPhrase(status) + "\n")
+		hdrs = GetDefaultHeaders(len(body))
+	}
+
+	err := w.WriteStatusLine(status)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(hdrs)
+	if err != nil || body == nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+// matchETag reports whether a list of entity tags, or "*", matches etag. The
+// strong comparison needs both to be strong and identical, the weak one only
+// compares the opaque tags (RFC 9110 section 8.8.3.2).
+func matchETag(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+	weak, opaque := splitETag(etag)
+	if strong && weak {
+		return false
+	}
+
+	for _, candidate := range parseETags(list) {
+		candidateWeak, candidateOpaque := splitETag(candidate)
+		if strong && candidateWeak {
+			continue
+		}
+		if candidateOpaque == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+func splitETag(etag string) (bool, string) {
+	if strings.HasPrefix(etag, "W/") {
+		return true, etag[2:]
+	}
+	return false, etag
+}
+
+// parseETags splits a comma separated list of entity tags. Commas are valid
+// inside the quotes, so a plain split won't do.
+func parseETags(list string) []string {
+	tags := []string{}
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return tags
+		}
+		prefix := ""
+		if strings.HasPrefix(list, "W/") {
+			prefix, list = "W/", list[2:]
+		}
+		if !strings.HasPrefix(list, `"`) {
+			return tags
+		}
+		end := strings.IndexByte(list[1:], '"')
+		if end < 0 {
+			return tags
+		}
+		tags = append(tags, prefix+list[:end+2])
+		list = list[end+2:]
+	}
+}
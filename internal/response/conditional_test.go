@@ -0,0 +1,84 @@
+package response
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+)
+
+func TestParseHTTPDate(t *testing.T) {
+	want := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+
+	// Test: IMF-fixdate and the two obsolete formats
+	for _, s := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		date, err := ParseHTTPDate(s)
+		require.NoError(t, err, s)
+		assert.True(t, want.Equal(date), s)
+	}
+	_, err := ParseHTTPDate("yesterday")
+	require.Error(t, err)
+
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatHTTPDate(want.In(time.FixedZone("X", 3600))))
+}
+
+func TestETags(t *testing.T) {
+	// Test: Strong tags follow the content, weak ones the metadata
+	assert.Equal(t, StrongETag([]byte("a")), StrongETag([]byte("a")))
+	assert.NotEqual(t, StrongETag([]byte("a")), StrongETag([]byte("b")))
+	modTime := time.Unix(1700000000, 0)
+	assert.Equal(t, `W/"17979cfe362a0000-a"`, WeakETag(modTime, 10))
+
+	// Test: Lists keep commas inside quotes
+	assert.Equal(t, []string{`"a,b"`, `W/"c"`, `"d"`}, parseETags(` "a,b", W/"c" ,"d"`))
+
+	// Test: Strong and weak comparison
+	assert.True(t, matchETag(`"x"`, `"x"`, true))
+	assert.False(t, matchETag(`W/"x"`, `"x"`, true))
+	assert.False(t, matchETag(`"x"`, `W/"x"`, true))
+	assert.True(t, matchETag(`W/"x"`, `"x"`, false))
+	assert.True(t, matchETag(`"y", W/"x"`, `W/"x"`, false))
+	assert.True(t, matchETag("*", `"x"`, true))
+	assert.False(t, matchETag(`"x"`, "", false))
+}
+
+func TestEvaluatePreconditions(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	v := Validators{ETag: `"v2"`, LastModified: modified}
+	before := "Tue, 30 Apr 2024 12:00:00 GMT"
+	after := "Thu, 02 May 2024 12:00:00 GMT"
+
+	tests := []struct {
+		name    string
+		method  string
+		headers []string
+		want    StatusCode
+	}{
+		{"no conditions", "GET", nil, StatusOK},
+		{"if-none-match hit", "GET", []string{"If-None-Match", `W/"v2"`}, StatusNotModified},
+		{"if-none-match miss", "GET", []string{"If-None-Match", `"v1"`}, StatusOK},
+		{"if-none-match hit on unsafe method", "PUT", []string{"If-None-Match", "*"}, StatusPreconditionFailed},
+		{"if-modified-since unchanged", "GET", []string{"If-Modified-Since", after}, StatusNotModified},
+		{"if-modified-since changed", "GET", []string{"If-Modified-Since", before}, StatusOK},
+		{"if-modified-since ignored for POST", "POST", []string{"If-Modified-Since", after}, StatusOK},
+		{"if-none-match wins over if-modified-since", "GET", []string{"If-None-Match", `"v1"`, "If-Modified-Since", after}, StatusOK},
+		{"if-match hit", "PUT", []string{"If-Match", `"v2"`}, StatusOK},
+		{"if-match weak never matches", "PUT", []string{"If-Match", `W/"v2"`}, StatusPreconditionFailed},
+		{"if-unmodified-since changed", "PUT", []string{"If-Unmodified-Since", before}, StatusPreconditionFailed},
+		{"if-match wins over if-unmodified-since", "PUT", []string{"If-Match", `"v2"`, "If-Unmodified-Since", before}, StatusOK},
+		{"invalid dates are ignored", "GET", []string{"If-Modified-Since", "soon"}, StatusOK},
+	}
+	for _, tt := range tests {
+		h := headers.NewHeaders()
+		for i := 0; i+1 < len(tt.headers); i += 2 {
+			h.Set(tt.headers[i], tt.headers[i+1])
+		}
+		assert.Equal(t, tt.want, EvaluatePreconditions(tt.method, h, v), tt.name)
+	}
+}
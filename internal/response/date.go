@@ -0,0 +1,25 @@
+package response
+
+import (
+	"sync"
+	"time"
+)
+
+// dateCache holds the Date header value for the current second, so that a
+// busy server formats it once per second instead of once per response.
+var dateCache struct {
+	mu    sync.Mutex
+	unix  int64
+	value string
+}
+
+// httpDate returns now formatted for the Date header.
+func httpDate(now time.Time) string {
+	dateCache.mu.Lock()
+	defer dateCache.mu.Unlock()
+	if sec := now.Unix(); sec != dateCache.unix || dateCache.value == "" {
+		dateCache.unix = sec
+		dateCache.value = FormatHTTPDate(now)
+	}
+	return dateCache.value
+}
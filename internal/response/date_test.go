@@ -0,0 +1,21 @@
+package response
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPDate(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 30, 15, 0, time.UTC)
+
+	// Test: The date is formatted once per second
+	assert.Equal(t, "Sun, 01 Mar 2026 12:30:15 GMT", httpDate(now))
+	assert.Equal(t, "Sun, 01 Mar 2026 12:30:15 GMT", httpDate(now.Add(999*time.Millisecond)))
+	assert.Equal(t, "Sun, 01 Mar 2026 12:30:16 GMT", httpDate(now.Add(time.Second)))
+
+	// Test: Other zones are formatted in GMT
+	est := time.FixedZone("EST", -5*60*60)
+	assert.Equal(t, "Sun, 01 Mar 2026 12:30:17 GMT", httpDate(now.Add(2*time.Second).In(est)))
+}
@@ -0,0 +1,83 @@
+package response
+
+import (
+	"encoding/json"
+)
+
+// WriteJSON writes v as the JSON body of a response with status.
+func WriteJSON(w *Writer, status StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeBody(w, status, "application/json", body)
+}
+
+// Problem is an RFC 9457 problem details object, the machine readable body
+// of an error response. Extensions are added as members next to the standard
+// ones, which they can't replace.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     StatusCode
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := map[string]any{}
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	//"about:blank" is what an absent type means, so it is spelled out
+	problemType := p.Type
+	if problemType == "" {
+		problemType = "about:blank"
+	}
+	members["type"] = problemType
+	if p.Title != "" {
+		members["title"] = p.Title
+	}
+	if p.Status != 0 {
+		members["status"] = int(p.Status)
+	}
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	return json.Marshal(members)
+}
+
+// WriteProblem writes p as an application/problem+json response with its
+// Status, 500 if unset. Without a Title the reason phrase is used.
+func WriteProblem(w *Writer, p Problem) error {
+	if p.Status == 0 {
+		p.Status = StatusInternalServerError
+	}
+	if p.Title == "" {
+		p.Title = ReasonPhrase(p.Status)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return writeBody(w, p.Status, "application/problem+json", body)
+}
+
+func writeBody(w *Writer, status StatusCode, contentType string, body []byte) error {
+	h := GetDefaultHeaders(len(body))
+	h.ForceSet("Content-Type", contentType)
+	err := w.WriteStatusLine(status)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
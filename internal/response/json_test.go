@@ -0,0 +1,75 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readResponse(t *testing.T, raw []byte) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteJSON(NewWriter(&buf), StatusCreated, map[string]any{"id": 7, "name": "ada"}))
+
+	// Test: The body is JSON with its exact length
+	resp, body := readResponse(t, buf.Bytes())
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+	assert.JSONEq(t, `{"id": 7, "name": "ada"}`, string(body))
+
+	// Test: Values that can't be encoded are reported before anything is
+	// written
+	buf.Reset()
+	require.Error(t, WriteJSON(NewWriter(&buf), StatusOK, func() {}))
+	assert.Zero(t, buf.Len())
+}
+
+func TestWriteProblem(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteProblem(NewWriter(&buf), Problem{
+		Type:       "https://example.com/probs/out-of-credit",
+		Status:     StatusForbidden,
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]any{"balance": 30, "status": "ignored"},
+	})
+	require.NoError(t, err)
+
+	// Test: Problems are problem+json, titled with the reason phrase, and
+	// extensions can't replace standard members
+	resp, body := readResponse(t, buf.Bytes())
+	assert.Equal(t, 403, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "https://example.com/probs/out-of-credit",
+		"title": "Forbidden",
+		"status": 403,
+		"detail": "Your current balance is 30, but that costs 50.",
+		"instance": "/account/12345/msgs/abc",
+		"balance": 30
+	}`, string(body))
+
+	// Test: An empty problem is a 500 of type about:blank
+	buf.Reset()
+	require.NoError(t, WriteProblem(NewWriter(&buf), Problem{}))
+	resp, body = readResponse(t, buf.Bytes())
+	assert.Equal(t, 500, resp.StatusCode)
+	var members map[string]any
+	require.NoError(t, json.Unmarshal(body, &members))
+	assert.Equal(t, map[string]any{"type": "about:blank", "title": "Internal Server Error", "status": float64(500)}, members)
+}
@@ -0,0 +1,70 @@
+package response
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NegotiateContentType picks the media type from offers the Accept header
+// prefers (RFC 9110 section 12.5.1). The most specific range matching an
+// offer decides its quality, and ties go to the earlier offer. Without an
+// Accept header the first offer is picked; "" means none is acceptable.
+func NegotiateContentType(accept string, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	type mediaRange struct {
+		typ, subtype string
+		q            float64
+	}
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(fields[0])), "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		ranges = append(ranges, mediaRange{typ, subtype, q})
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, offer := range offers {
+		typ, subtype, _ := strings.Cut(strings.ToLower(offer), "/")
+		q := 0.0
+		specificity := -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.typ == typ && r.subtype == subtype:
+				s = 2
+			case r.typ == typ && r.subtype == "*":
+				s = 1
+			case r.typ == "*" && r.subtype == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,29 @@
+package response
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"text/plain", "text/html", "application/json"}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "text/plain"},
+		{"*/*", "text/plain"},
+		{"application/json", "application/json"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html"},
+		{"text/*;q=0.5, application/json", "application/json"},
+		{"text/*, text/plain;q=0", "text/html"},
+		{"application/json;q=0.2, text/html;q=0.2", "text/html"},
+		{"image/png", ""},
+		{"*/*;q=0", ""},
+		{"garbage, application/JSON", "application/json"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NegotiateContentType(tt.accept, offers...), tt.accept)
+	}
+}
@@ -1,9 +1,14 @@
 package response
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
+	"time"
 
 	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
 )
@@ -11,12 +16,88 @@ import (
 type StatusCode int
 
 const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
-	StatusUnknown             StatusCode = 0
+	StatusContinue                    StatusCode = 100
+	StatusSwitchingProtocols          StatusCode = 101
+	StatusOK                          StatusCode = 200
+	StatusCreated                     StatusCode = 201
+	StatusAccepted                    StatusCode = 202
+	StatusNoContent                   StatusCode = 204
+	StatusPartialContent              StatusCode = 206
+	StatusMovedPermanently            StatusCode = 301
+	StatusFound                       StatusCode = 302
+	StatusSeeOther                    StatusCode = 303
+	StatusNotModified                 StatusCode = 304
+	StatusTemporaryRedirect           StatusCode = 307
+	StatusPermanentRedirect           StatusCode = 308
+	StatusBadRequest                  StatusCode = 400
+	StatusUnauthorized                StatusCode = 401
+	StatusForbidden                   StatusCode = 403
+	StatusNotFound                    StatusCode = 404
+	StatusMethodNotAllowed            StatusCode = 405
+	StatusNotAcceptable               StatusCode = 406
+	StatusRequestTimeout              StatusCode = 408
+	StatusConflict                    StatusCode = 409
+	StatusGone                        StatusCode = 410
+	StatusLengthRequired              StatusCode = 411
+	StatusPreconditionFailed          StatusCode = 412
+	StatusContentTooLarge             StatusCode = 413
+	StatusURITooLong                  StatusCode = 414
+	StatusUnsupportedMediaType        StatusCode = 415
+	StatusRangeNotSatisfiable         StatusCode = 416
+	StatusUnprocessableContent        StatusCode = 422
+	StatusUpgradeRequired             StatusCode = 426
+	StatusTooManyRequests             StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge StatusCode = 431
+	StatusInternalServerError         StatusCode = 500
+	StatusNotImplemented              StatusCode = 501
+	StatusBadGateway                  StatusCode = 502
+	StatusServiceUnavailable          StatusCode = 503
+	StatusGatewayTimeout              StatusCode = 504
+	StatusHTTPVersionNotSupported     StatusCode = 505
+	StatusUnknown                     StatusCode = 0
 )
 
+var reasonPhrases = map[StatusCode]string{
+	StatusContinue:                    "Continue",
+	StatusSwitchingProtocols:          "Switching Protocols",
+	StatusOK:                          "OK",
+	StatusCreated:                     "Created",
+	StatusAccepted:                    "Accepted",
+	StatusNoContent:                   "No Content",
+	StatusPartialContent:              "Partial Content",
+	StatusMovedPermanently:            "Moved Permanently",
+	StatusFound:                       "Found",
+	StatusSeeOther:                    "See Other",
+	StatusNotModified:                 "Not Modified",
+	StatusTemporaryRedirect:           "Temporary Redirect",
+	StatusPermanentRedirect:           "Permanent Redirect",
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusNotAcceptable:               "Not Acceptable",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusConflict:                    "Conflict",
+	StatusGone:                        "Gone",
+	StatusLengthRequired:              "Length Required",
+	StatusPreconditionFailed:          "Precondition Failed",
+	StatusContentTooLarge:             "Content Too Large",
+	StatusURITooLong:                  "URI Too Long",
+	StatusUnsupportedMediaType:        "Unsupported Media Type",
+	StatusRangeNotSatisfiable:         "Range Not Satisfiable",
+	StatusUnprocessableContent:        "Unprocessable Content",
+	StatusUpgradeRequired:             "Upgrade Required",
+	StatusTooManyRequests:             "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusInternalServerError:         "Internal Server Error",
+	StatusNotImplemented:              "Not Implemented",
+	StatusBadGateway:                  "Bad Gateway",
+	StatusServiceUnavailable:          "Service Unavailable",
+	StatusGatewayTimeout:              "Gateway Timeout",
+	StatusHTTPVersionNotSupported:     "HTTP Version Not Supported",
+}
+
 type WriterState int
 
 const (
@@ -27,124 +108,623 @@ const (
 	StateDone
 )
 
+func (s WriterState) String() string {
+	switch s {
+	case StateWritingStatusLine:
+		return "writing status line"
+	case StateWritingHeaders:
+		return "writing headers"
+	case StateWritingBody:
+		return "writing body"
+	case StateWritingTrailers:
+		return "writing trailers"
+	case StateDone:
+		return "done"
+	}
+	return fmt.Sprintf("WriterState(%d)", int(s))
+}
+
+// Misuse of a Writer is reported with these errors, wrapped with details.
+var (
+	ErrWrongState         = errors.New("response written out of order")
+	ErrBodyNotAllowed     = errors.New("response must not have a body")
+	ErrBodyLengthExceeded = errors.New("body longer than its content-length")
+	ErrShortBody          = errors.New("body shorter than its content-length")
+	ErrNotChunked         = errors.New("response does not use chunked encoding")
+)
+
+// Writer writes a response in order: status line, headers, body, and for
+// chunked responses trailers. It frames the body the way the headers
+// declare: a Content-Length is checked against the bytes written, a chunked
+// Transfer-Encoding is applied, and without either the Writer picks one
+// itself. 1xx, 204 and 304 responses can't have a body, and the body of a
+// response to HEAD is discarded, only counted for its Content-Length.
 type Writer struct {
-	writer      io.Writer
-	WriterState WriterState
+	writer io.Writer
+	state  WriterState
+
+	conn     net.Conn
+	buffered []byte
+	hijacked bool
+	//client is conn, also for the Writers wrapping this one
+	client net.Conn
+
+	transport  Transport
+	statusCode StatusCode
+
+	method        string
+	noBody        bool
+	head          bool
+	chunked       bool
+	contentLength int64
+	written       int64
+	bw            *bufio.Writer
+	pending       headers.Headers
+
+	serverName string
 }
 
+// bodyBufferSize is how much body a Writer collects before writing it to
+// the connection, as one chunk when chunked.
+const bodyBufferSize = 4096
+
 func NewWriter(c io.Writer) *Writer {
 	return &Writer{
-		writer:      c,
-		WriterState: StateWritingStatusLine,
+		writer: c,
+		state:  StateWritingStatusLine,
+	}
+}
+
+// NewConnWriter returns a Writer for a response sent over conn that handlers
+// can Hijack. buffered holds the bytes already read from conn past the end of
+// the request.
+func NewConnWriter(conn net.Conn, buffered []byte) *Writer {
+	return &Writer{
+		writer:   conn,
+		state:    StateWritingStatusLine,
+		conn:     conn,
+		buffered: buffered,
+		client:   conn,
+	}
+}
+
+// SetServerName sets the product token sent as the Server header of
+// responses that don't have one. Without it no Server header is sent.
+func (w *Writer) SetServerName(name string) {
+	w.serverName = name
+}
+
+// SetRequestMethod tells the Writer the method of the request it answers,
+// so that it knows to discard the body of a response to HEAD.
+func (w *Writer) SetRequestMethod(method string) {
+	w.method = method
+}
+
+// State reports how far the response has got, so that callers can tell
+// whether anything was written yet. Only the Writer's own methods move it.
+func (w *Writer) State() WriterState {
+	return w.state
+}
+
+func (w *Writer) checkState(want WriterState, what string) error {
+	if w.state != want {
+		return fmt.Errorf("%w: cannot write %s while %s", ErrWrongState, what, w.state)
 	}
+	return nil
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	err := w.checkState(StateWritingStatusLine, "status line")
+	if err != nil {
+		return err
+	}
+	w.statusCode = statusCode
 
-	if w.WriterState != StateWritingStatusLine {
-		return fmt.Errorf("error writing status line while not in State Writing Status Line")
+	if w.transport != nil {
+		w.state = StateWritingHeaders
+		return nil
 	}
 
 	reasonPhrase := ReasonPhrase(statusCode)
 
 	reasonPhraseBytes := []byte(fmt.Sprintf("HTTP/1.1 %v %s\r\n", statusCode, reasonPhrase))
 
-	_, err := w.writer.Write(reasonPhraseBytes)
-	w.WriterState = StateWritingHeaders
+	_, err = w.writer.Write(reasonPhraseBytes)
+	w.state = StateWritingHeaders
 	return err
 
 }
 
+// WriteHeaders writes the headers and picks the framing of the body from
+// them. Date is added when missing, and Server when SetServerName gave one.
+// A response that may have a body but declares neither Content-Length nor
+// Transfer-Encoding holds its headers back until the body is known: one
+// finished within the buffer gets a Content-Length, a longer or flushed one
+// is sent chunked.
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	if w.WriterState != StateWritingHeaders {
-		return fmt.Errorf("error writing status line while not in State Writing Headers")
+	err := w.checkState(StateWritingHeaders, "headers")
+	if err != nil {
+		return err
 	}
-defer func() { w.WriterState = StateWritingBody }()
-	for key, value := range headers {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
-		if err != nil {
-			return err
+
+	if _, ok := headers.Get("date"); !ok && w.statusCode >= 200 {
+		headers.Set("Date", httpDate(time.Now()))
+	}
+	if _, ok := headers.Get("server"); !ok && w.serverName != "" {
+		headers.Set("Server", w.serverName)
+	}
+
+	w.noBody = w.method == "HEAD" || !BodyAllowed(w.statusCode)
+	w.head = w.method == "HEAD" && BodyAllowed(w.statusCode)
+	w.contentLength = -1
+	_, hasTE := headers.Get("transfer-encoding")
+	if hasTE {
+		w.chunked = headers.IsChunked()
+	} else if cl, ok := headers.Get("content-length"); ok {
+		w.contentLength, err = strconv.ParseInt(strings.TrimSpace(cl), 10, 64)
+		if err != nil || w.contentLength < 0 {
+			return fmt.Errorf("invalid content-length %q", cl)
 		}
 	}
 
+	defer func() { w.state = StateWritingBody }()
+	//a HEAD response without a length gets the one its GET would have, over
+	//transports too
+	if w.head && !hasTE && w.contentLength < 0 {
+		w.pending = headers
+		return nil
+	}
+	//framing is the transport's business, it gets the body unframed
+	if w.transport != nil {
+		return w.transport.WriteHeader(w.statusCode, headers)
+	}
+	if w.noBody {
+		return w.writeHeaderBlock(headers)
+	}
+	w.bw = bufio.NewWriterSize(frameWriter{w}, bodyBufferSize)
+	if !hasTE && w.contentLength < 0 {
+		w.pending = headers
+		return nil
+	}
+	return w.writeHeaderBlock(headers)
+}
+
+func (w *Writer) writeHeaderBlock(h headers.Headers) error {
+	for key := range h {
+		for _, value := range h.Values(key) {
+			_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
+			if err != nil {
+				return err
+			}
+		}
+	}
 	_, err := w.writer.Write([]byte("\r\n"))
 	return err
 }
 
-func (w *Writer) WriteBody(data []byte) (int, error) {
+// commitHeaders writes the headers held back for a body of unknown length
+// now that its framing is known: a Content-Length of length, or chunked when
+// length is negative.
+func (w *Writer) commitHeaders(length int) error {
+	h := w.pending
+	w.pending = nil
+	if length >= 0 {
+		h.Set("Content-Length", strconv.Itoa(length))
+		w.contentLength = int64(length)
+	} else {
+		h.Set("Transfer-Encoding", "chunked")
+		w.chunked = true
+	}
+	if w.transport != nil {
+		return w.transport.WriteHeader(w.statusCode, h)
+	}
+	return w.writeHeaderBlock(h)
+}
 
-	if w.WriterState != StateWritingBody {
-		return 0, fmt.Errorf("error writing status line while not in State Writing Status Line")
+// Write writes p as part of the body, so that the Writer is an io.Writer
+// handlers can render or copy into. It can be called any number of times
+// until the response is finished and never writes past the declared
+// Content-Length. Writes are buffered, Flush sends them right away.
+func (w *Writer) Write(p []byte) (int, error) {
+	err := w.checkState(StateWritingBody, "body")
+	if err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.head {
+		//the body of a HEAD response is only counted
+		w.written += int64(len(p))
+		return len(p), nil
+	}
+	if w.noBody {
+		return 0, fmt.Errorf("%w: status %d to %s", ErrBodyNotAllowed, w.statusCode, w.methodOrGET())
 	}
-	defer func () {w.WriterState = StateDone} ()
-	n, err := w.writer.Write(data)
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		//write what fits so the framing of the response stays intact
+		n, err := w.writeBody(p[:w.contentLength-w.written])
+		if err != nil {
+			return n, err
+		}
+		return n, fmt.Errorf("%w: %d bytes declared", ErrBodyLengthExceeded, w.contentLength)
+	}
+	return w.writeBody(p)
+}
+
+func (w *Writer) writeBody(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var n int
+	var err error
+	if w.transport != nil {
+		n, err = w.transport.WriteBody(p)
+	} else {
+		n, err = w.bw.Write(p)
+	}
+	w.written += int64(n)
+	return n, err
+}
+
+// frameWriter writes buffered body bytes to the connection with the framing
+// of the response, one chunk per flush of the buffer when chunked.
+type frameWriter struct {
+	w *Writer
+}
+
+func (fw frameWriter) Write(p []byte) (int, error) {
+	//the buffer overflowed before the body was finished
+	if fw.w.pending != nil {
+		err := fw.w.commitHeaders(-1)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if !fw.w.chunked {
+		return fw.w.writer.Write(p)
+	}
+	_, err := fmt.Fprintf(fw.w.writer, "%x\r\n", len(p))
 	if err != nil {
-		return 0, fmt.Errorf("error writing to body")
+		return 0, err
 	}
-	_, err = w.writer.Write([]byte("\r\n"))
+	n, err := fw.w.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = fw.w.writer.Write([]byte("\r\n"))
 	return n, err
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	if w.WriterState != StateWritingBody {
-		return 0, fmt.Errorf("cannot write body in state %d", w.WriterState)
+// bodyWriter hides ReadFrom, so that copies into the body made by ReadFrom
+// itself go through Write.
+type bodyWriter struct {
+	w *Writer
+}
+
+func (bw bodyWriter) Write(p []byte) (int, error) {
+	return bw.w.Write(p)
+}
+
+func (w *Writer) methodOrGET() string {
+	if w.method == "" {
+		return "GET"
 	}
-	chunkSize := len(p)
+	return w.method
+}
 
-	nTotal := 0
-	n, err := fmt.Fprintf(w.writer, "%x\r\n", chunkSize)
+// Flush sends the body written so far to the client, through the Transport
+// too when it implements Flusher. Streaming handlers call it after each
+// piece the client should see immediately.
+func (w *Writer) Flush() error {
+	if w.pending != nil {
+		err := w.commitHeaders(-1)
+		if err != nil {
+			return err
+		}
+	}
+	if w.bw != nil {
+		err := w.bw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	if f, ok := w.transport.(Flusher); ok && w.state == StateWritingBody {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Finish ends the response: buffered body bytes are sent, a chunked body
+// gets its last chunk, and a body shorter than its Content-Length is
+// reported with ErrShortBody. Finishing a finished or hijacked response does
+// nothing.
+func (w *Writer) Finish() error {
+	switch w.state {
+	case StateDone:
+		return nil
+	case StateWritingTrailers:
+		return w.WriteTrailers(nil)
+	}
+	err := w.checkState(StateWritingBody, "end of body")
 	if err != nil {
-		return nTotal, err
+		return err
+	}
+	if w.pending != nil {
+		//nothing was sent yet, so the whole body is known
+		err = w.commitHeaders(int(w.written))
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	w.state = StateDone
+	if err != nil {
+		return err
 	}
-	nTotal += n
 
-	n, err = w.writer.Write(p)
+	if !w.noBody && w.contentLength >= 0 && w.written < w.contentLength {
+		return fmt.Errorf("%w: wrote %d of %d bytes", ErrShortBody, w.written, w.contentLength)
+	}
+	if w.transport != nil {
+		return w.transport.Finish(nil)
+	}
+	if w.chunked && !w.noBody {
+		_, err = w.writer.Write([]byte("0\r\n\r\n"))
+	}
+	return err
+}
+
+// Abort cuts the response off where it is, for handlers that can't complete
+// a body they already started, like a proxy losing its upstream. The client
+// has to see that the response is incomplete, so nothing ending the body is
+// sent: the connection is closed, or the Transport aborts when it implements
+// Aborter. Aborting a finished or hijacked response does nothing.
+func (w *Writer) Abort() error {
+	if w.state == StateDone {
+		return nil
+	}
+	w.state = StateDone
+	if w.transport != nil {
+		if a, ok := w.transport.(Aborter); ok {
+			return a.Abort()
+		}
+		return nil
+	}
+	if w.conn != nil {
+		return w.conn.Close()
+	}
+	return nil
+}
+
+// WriteBody writes data as the rest of the body and finishes the response.
+func (w *Writer) WriteBody(data []byte) (int, error) {
+	n, err := w.Write(data)
+	if errors.Is(err, ErrWrongState) {
+		return n, err
+	}
+	finishErr := w.Finish()
+	if err == nil {
+		err = finishErr
+	}
+	return n, err
+}
+
+// ReadFrom copies r into the body until EOF, so that io.Copy to the Writer
+// streams files, pipes or upstream responses. Like Write it can be followed
+// by more body.
+//
+// With a Content-Length, r is handed to the connection unwrapped: when it is
+// an *os.File, or an *io.LimitedReader around one no longer than the rest of
+// the body, and the connection a *net.TCPConn, io.Copy ends in
+// TCPConn.ReadFrom and the kernel sends the file with sendfile, never
+// copying it through user space.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	err := w.checkState(StateWritingBody, "body")
+	if err != nil {
+		return 0, err
+	}
+	if w.head {
+		//r only has to be read when its length is still needed
+		if w.pending == nil {
+			return 0, nil
+		}
+		n, err := io.Copy(io.Discard, r)
+		w.written += n
+		return n, err
+	}
+	if w.noBody {
+		return 0, fmt.Errorf("%w: status %d to %s", ErrBodyNotAllowed, w.statusCode, w.methodOrGET())
+	}
+	if w.transport != nil || w.chunked || w.contentLength < 0 {
+		return io.Copy(bodyWriter{w}, r)
+	}
+
+	err = w.Flush()
 	if err != nil {
-		return nTotal, err
+		return 0, err
 	}
-	nTotal += n
+	remaining := w.contentLength - w.written
+	src := r
+	if lr, ok := r.(*io.LimitedReader); !ok || lr.N > remaining {
+		src = io.LimitReader(r, remaining)
+	}
+	n, err := io.Copy(w.writer, src)
+	w.written += n
+	if err != nil || n < remaining {
+		return n, err
+	}
+	//the body is complete, anything r still has is more than was declared
+	var extra [1]byte
+	if m, _ := r.Read(extra[:]); m > 0 {
+		return n, fmt.Errorf("%w: %d bytes declared", ErrBodyLengthExceeded, w.contentLength)
+	}
+	return n, nil
+}
 
-	n, err = w.writer.Write([]byte("\r\n"))
+// WriteBodyFrom streams r as the rest of the body and finishes the response,
+// like WriteBody without holding the body in memory. It keeps sendfile the
+// way ReadFrom does, and hands r to Transports implementing BodyReaderFrom.
+func (w *Writer) WriteBodyFrom(r io.Reader) (int64, error) {
+	err := w.checkState(StateWritingBody, "body")
 	if err != nil {
-		return nTotal, err
+		return 0, err
+	}
+	if w.noBody && !w.head {
+		w.state = StateDone
+		return 0, fmt.Errorf("%w: status %d to %s", ErrBodyNotAllowed, w.statusCode, w.methodOrGET())
+	}
+
+	var n int64
+	if rf, ok := w.transport.(BodyReaderFrom); ok && !w.head {
+		n, err = rf.WriteBodyFrom(r)
+		w.written += n
+	} else {
+		n, err = w.ReadFrom(r)
+	}
+	if err != nil {
+		w.state = StateDone
+		return n, err
+	}
+	return n, w.Finish()
+}
+
+// WriteChunkedBody writes p as a chunk of a chunked body and flushes it.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	err := w.checkState(StateWritingBody, "body")
+	if err != nil {
+		return 0, err
+	}
+	if w.transport == nil && !w.chunked && w.pending == nil && !w.noBody {
+		return 0, ErrNotChunked
+	}
+	n, err := w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Flush()
+}
+
+// NewChunkWriter returns an io.Writer sending everything written to it as
+// chunks of w's body, for io.Copy into a chunked response. Empty writes are
+// dropped, an empty chunk would end the body.
+func NewChunkWriter(w *Writer) io.Writer {
+	return chunkWriter{w}
+}
+
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
 	}
-	nTotal += n
-	return chunkSize, nil
+	return cw.w.WriteChunkedBody(p)
 }
 
+// WriteChunkedbodyDone writes the last chunk of a chunked body. Trailers
+// have to follow with WriteTrailers.
 func (w *Writer) WriteChunkedbodyDone() (int, error) {
-	if w.WriterState != StateWritingBody {
-		return 0, fmt.Errorf("error writing body in while state: '%v'", w.WriterState)
+	err := w.checkState(StateWritingBody, "end of body")
+	if err != nil {
+		return 0, err
+	}
+	if w.transport != nil || w.noBody {
+		if w.pending != nil {
+			err = w.commitHeaders(int(w.written))
+			if err != nil {
+				return 0, err
+			}
+		}
+		w.state = StateWritingTrailers
+		return 0, nil
+	}
+	if w.pending == nil && !w.chunked {
+		return 0, ErrNotChunked
+	}
+	err = w.Flush()
+	if err != nil {
+		return 0, err
 	}
 	n, err := w.writer.Write([]byte("0\r\n"))
 	if err != nil {
 		return n, err
 	}
-	w.WriterState = StateWritingTrailers
+	w.state = StateWritingTrailers
 	return n, nil
 }
 
-
+// WriteTrailers writes the trailers after the last chunk and finishes the
+// response.
 func (w *Writer) WriteTrailers(h headers.Headers) error {
-	if w.WriterState != StateWritingTrailers {
-		return fmt.Errorf("error writing trailers in non-trailer state")
+	err := w.checkState(StateWritingTrailers, "trailers")
+	if err != nil {
+		return err
 	}
 
-	defer func() {w.WriterState = StateWritingBody} ()
-	
-	//log.Printf("headers in trailers: %v", h)
-	for key, value := range h {
-		data := []byte(fmt.Sprintf("%s: %s\r\n", key, value))
-		_, err := w.writer.Write(data)
-		if err != nil {
-			return err
+	defer func() { w.state = StateDone }()
+	if w.transport != nil {
+		return w.transport.Finish(h)
+	}
+	if w.noBody {
+		return nil
+	}
+
+	for key := range h {
+		for _, value := range h.Values(key) {
+			data := []byte(fmt.Sprintf("%s: %s\r\n", key, value))
+			_, err := w.writer.Write(data)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	_, err := w.writer.Write([]byte("\r\n"))
+	_, err = w.writer.Write([]byte("\r\n"))
 	return err
 }
 
+// BodyAllowed reports whether a response with status can have a body.
+func BodyAllowed(status StatusCode) bool {
+	return status >= 200 && status != StatusNoContent && status != StatusNotModified
+}
+
+// Hijack hands the underlying connection over to the caller together with any
+// bytes the server read past the end of the request. Whatever was written
+// through the Writer before is flushed to the wire; afterwards the caller owns
+// the connection and has to close it, the server no longer touches it.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.conn == nil {
+		return nil, nil, fmt.Errorf("response writer is not backed by a connection")
+	}
+	if w.hijacked {
+		return nil, nil, fmt.Errorf("connection already hijacked")
+	}
+	if w.state == StateWritingBody {
+		err := w.Flush()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	w.hijacked = true
+	w.state = StateDone
+	buffered := w.buffered
+	w.buffered = nil
+	return w.conn, buffered, nil
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// ClientConn returns the connection the request came in on, or nil when the
+// response goes to a Transport of its own, as over HTTP/2. It is meant for
+// reading, to notice the client going away while a long response is written;
+// everything written still goes through the Writer.
+func (w *Writer) ClientConn() net.Conn {
+	return w.client
+}
+
 func GetHeading(status StatusCode) string {
 	switch status {
 	case StatusOK:
@@ -158,31 +738,22 @@ func GetHeading(status StatusCode) string {
 	}
 }
 
-
+// GetDefaultHeaders returns the headers of a plain text response with a body
+// of contentLen bytes. A negative contentLen leaves Content-Length out for
+// the Writer to work out from the body.
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.NewHeaders()
 
-	contentLenInt := strconv.Itoa(contentLen)
-
-	h.Set("Content-Length", contentLenInt)
+	if contentLen >= 0 {
+		h.Set("Content-Length", strconv.Itoa(contentLen))
+	}
 	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 
 	return h
 
-	
 }
 
 func ReasonPhrase(s StatusCode) string {
-
-	switch s {
-	case StatusOK:
-		return "OK"
-	case StatusBadRequest:
-		return "Bad Request"
-	case StatusInternalServerError:
-		return "Internal Server Error"
-	default:
-		return ""
-	}
+	return reasonPhrases[s]
 }
@@ -0,0 +1,411 @@
+package response
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+)
+
+// readFromRecorder is a connection stand-in that records what io.Copy hands
+// to ReadFrom.
+type readFromRecorder struct {
+	bytes.Buffer
+	source io.Reader
+}
+
+func (r *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.source = src
+	return r.Buffer.ReadFrom(src)
+}
+
+func writeTempFile(t testing.TB, size int) string {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	name := filepath.Join(t.TempDir(), "body.bin")
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+	return name
+}
+
+func TestWriteBodyFromFile(t *testing.T) {
+	name := writeTempFile(t, 4096)
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	conn := &readFromRecorder{}
+	w := NewWriter(conn)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(4096)))
+	head := conn.Len()
+
+	// Test: The file reaches the connection's ReadFrom unwrapped, which is
+	// where net.TCPConn switches to sendfile
+	n, err := w.WriteBodyFrom(io.LimitReader(f, 4096))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4096), n)
+	limited, ok := conn.source.(*io.LimitedReader)
+	require.True(t, ok)
+	assert.Same(t, f, limited.R)
+	assert.Equal(t, head+4096, conn.Len())
+
+	// Test: The body can only be written once
+	_, err = w.WriteBodyFrom(bytes.NewReader([]byte("more")))
+	require.Error(t, err)
+}
+
+func TestWriterFraming(t *testing.T) {
+	// Test: A body written in pieces fills its Content-Length exactly, with
+	// nothing after it
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("world"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhelloworld")))
+	assert.Equal(t, StateDone, w.State())
+
+	// Test: Bytes past the Content-Length are refused, short bodies reported
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(3))
+	n, err := w.Write([]byte("hello"))
+	assert.ErrorIs(t, err, ErrBodyLengthExceeded)
+	assert.Equal(t, 3, n)
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhel")))
+	w = NewWriter(&bytes.Buffer{})
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(3))
+	w.Write([]byte("h"))
+	assert.ErrorIs(t, w.Finish(), ErrShortBody)
+
+	// Test: Without a length, a body finished within the buffer gets a
+	// Content-Length
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	require.NoError(t, w.WriteHeaders(headers.Headers{"content-type": "text/plain"}))
+	w.Write([]byte("hello "))
+	w.Write(nil)
+	w.Write([]byte("world"))
+	require.NoError(t, w.Finish())
+	resp, body := readResponse(t, buf.Bytes())
+	assert.Nil(t, resp.TransferEncoding)
+	assert.Equal(t, int64(11), resp.ContentLength)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: A longer one is sent chunked
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"content-type": "text/plain"})
+	long := strings.Repeat("x", bodyBufferSize+1)
+	w.Write([]byte(long))
+	require.NoError(t, w.Finish())
+	resp, body = readResponse(t, buf.Bytes())
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, long, string(body))
+
+	// Test: Chunk writes need a chunked response
+	w = NewWriter(&bytes.Buffer{})
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(5))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	assert.ErrorIs(t, err, ErrNotChunked)
+
+	// Test: Trailers end the response
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked", "trailer": "X-Sum"})
+	w.WriteChunkedBody([]byte("hi"))
+	w.WriteChunkedbodyDone()
+	require.NoError(t, w.WriteTrailers(headers.Headers{"x-sum": "2"}))
+	assert.Equal(t, StateDone, w.State())
+	_, err = w.WriteChunkedBody([]byte("more"))
+	assert.ErrorIs(t, err, ErrWrongState)
+	resp, body = readResponse(t, buf.Bytes())
+	assert.Equal(t, "hi", string(body))
+	assert.Equal(t, "2", resp.Trailer.Get("X-Sum"))
+
+	// Test: Calls out of order are errors
+	w = NewWriter(&bytes.Buffer{})
+	assert.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(0)), ErrWrongState)
+	_, err = w.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrWrongState)
+}
+
+func TestWriterDefaultHeaders(t *testing.T) {
+	// Test: Responses get a current Date, and Server once it is set
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetServerName("httpfromtcp")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+	resp, _ := readResponse(t, buf.Bytes())
+	date, err := ParseHTTPDate(resp.Header.Get("Date"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, 2*time.Second)
+	assert.Equal(t, "httpfromtcp", resp.Header.Get("Server"))
+
+	// Test: GetDefaultHeaders can leave the length to the Writer
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(-1))
+	w.WriteBody([]byte("computed"))
+	resp, _ = readResponse(t, buf.Bytes())
+	assert.Equal(t, int64(8), resp.ContentLength)
+
+	// Test: Headers the handler set win, and without a name there is no
+	// Server header
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"date": "Sun, 01 Mar 2026 12:30:15 GMT", "content-length": "0"})
+	w.Finish()
+	resp, _ = readResponse(t, buf.Bytes())
+	assert.Equal(t, "Sun, 01 Mar 2026 12:30:15 GMT", resp.Header.Get("Date"))
+	_, ok := resp.Header["Server"]
+	assert.False(t, ok)
+}
+
+func TestWriterNoBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status StatusCode
+	}{
+		{"204", "GET", StatusNoContent},
+		{"304", "GET", StatusNotModified},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetRequestMethod(tt.method)
+		w.WriteStatusLine(tt.status)
+		require.NoError(t, w.WriteHeaders(headers.Headers{"etag": `"v1"`}), tt.name)
+
+		// Test: Responses that can't have a body refuse one, and aren't
+		// made chunked
+		_, err := w.WriteBody([]byte("body"))
+		assert.ErrorIs(t, err, ErrBodyNotAllowed, tt.name)
+		assert.Equal(t, StateDone, w.State(), tt.name)
+		assert.NotContains(t, buf.String(), "chunked", tt.name)
+		assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n")), tt.name)
+	}
+}
+
+func TestWriterStreaming(t *testing.T) {
+	// Test: Templates render straight into the body, their many small writes
+	// buffered into a single chunk
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"content-type": "text/html"})
+	tmpl := template.Must(template.New("list").Parse(`<ul>{{range .}}<li>{{.}}</li>{{end}}</ul>`))
+	require.NoError(t, tmpl.Execute(w, []string{"a", "b", "c"}))
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finish())
+	page := "<ul><li>a</li><li>b</li><li>c</li></ul>"
+	assert.True(t, strings.HasSuffix(buf.String(), fmt.Sprintf("\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(page), page)))
+
+	// Test: Flush sends what was written so far, with the headers held back
+	// for a body of unknown length
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"content-type": "text/plain"})
+	head := buf.Len()
+	w.Write([]byte("tick"))
+	assert.Equal(t, head, buf.Len())
+	require.NoError(t, w.Flush())
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n4\r\ntick\r\n"))
+
+	// Test: io.Copy streams a reader of unknown length, then more body can
+	// follow
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"content-type": "text/plain"})
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(pw, "line %d\n", i)
+		}
+		pw.Close()
+	}()
+	n, err := io.Copy(w, pr)
+	require.NoError(t, err)
+	assert.Equal(t, int64(21), n)
+	w.Write([]byte("end\n"))
+	require.NoError(t, w.Finish())
+	_, body := readResponse(t, buf.Bytes())
+	assert.Equal(t, "line 0\nline 1\nline 2\nend\n", string(body))
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	name := writeTempFile(t, 4096)
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	conn := &readFromRecorder{}
+	w := NewWriter(conn)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(4096 + 5))
+	w.Write([]byte("head:"))
+
+	// Test: io.Copy of a file with a Content-Length flushes the buffer and
+	// reaches the connection's ReadFrom
+	n, err := io.Copy(w, io.LimitReader(f, 4096))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4096), n)
+	limited, ok := conn.source.(*io.LimitedReader)
+	require.True(t, ok)
+	assert.Same(t, f, limited.R)
+	require.NoError(t, w.Finish())
+	_, body := readResponse(t, conn.Bytes())
+	assert.Equal(t, 4096+5, len(body))
+	assert.Equal(t, "head:", string(body[:5]))
+
+	// Test: Copying more than the Content-Length is an error
+	w = NewWriter(&bytes.Buffer{})
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(3))
+	n, err = io.Copy(w, strings.NewReader("hello"))
+	assert.ErrorIs(t, err, ErrBodyLengthExceeded)
+	assert.Equal(t, int64(3), n)
+}
+
+// failReader fails the test when read.
+type failReader struct {
+	t *testing.T
+}
+
+func (r failReader) Read(p []byte) (int, error) {
+	r.t.Error("body of a HEAD response read")
+	return 0, io.EOF
+}
+
+func TestWriterHead(t *testing.T) {
+	// Test: The body of a HEAD response is discarded, but counted for a
+	// Content-Length matching GET
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetRequestMethod("HEAD")
+	w.WriteStatusLine(StatusOK)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(-1)))
+	n, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+	_, err = io.Copy(w, strings.NewReader("world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.NotContains(t, buf.String(), "hello")
+
+	// Test: A declared length is kept and streamed bodies aren't read
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetRequestMethod("HEAD")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(4096))
+	_, err = w.WriteBodyFrom(failReader{t})
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "content-length: 4096\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+}
+
+// benchmarkConn returns the server side of a TCP connection whose client
+// discards everything it receives.
+func benchmarkConn(b *testing.B) net.Conn {
+	b.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}()
+	conn, err := l.Accept()
+	require.NoError(b, err)
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+const benchmarkFileSize = 8 << 20
+
+// BenchmarkReadFileWriteBody serves a file the way handleVideo used to:
+// os.ReadFile into memory, then WriteBody.
+func BenchmarkReadFileWriteBody(b *testing.B) {
+	name := writeTempFile(b, benchmarkFileSize)
+	conn := benchmarkConn(b)
+	b.SetBytes(benchmarkFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w := NewWriter(conn)
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(GetDefaultHeaders(len(data)))
+		_, err = w.WriteBody(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteBodyFromFile serves the same file through WriteBodyFrom,
+// which lets the kernel send it with sendfile.
+func BenchmarkWriteBodyFromFile(b *testing.B) {
+	name := writeTempFile(b, benchmarkFileSize)
+	conn := benchmarkConn(b)
+	b.SetBytes(benchmarkFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w := NewWriter(conn)
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(GetDefaultHeaders(benchmarkFileSize))
+		_, err = w.WriteBodyFrom(io.LimitReader(f, benchmarkFileSize))
+		f.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package response
+
+import (
+	"io"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+)
+
+// Transport receives the parts of a response from a Writer instead of the
+// Writer serializing them as HTTP/1.1. It lets other protocols, like HTTP/2,
+// run the same handlers. The Writer still enforces the order of the calls.
+type Transport interface {
+	// WriteHeader is called once with the status code and headers.
+	WriteHeader(statusCode StatusCode, h headers.Headers) error
+	// WriteBody is called with body data, without any chunked framing.
+	WriteBody(p []byte) (int, error)
+	// Finish ends the response, with the trailers if any were written.
+	Finish(trailers headers.Headers) error
+}
+
+// BodyReaderFrom is implemented by Transports that take a streamed body as a
+// whole, so WriteBodyFrom can hand them the reader instead of copying it
+// through WriteBody piece by piece.
+type BodyReaderFrom interface {
+	WriteBodyFrom(r io.Reader) (int64, error)
+}
+
+func NewTransportWriter(t Transport) *Writer {
+	return &Writer{
+		state:     StateWritingStatusLine,
+		transport: t,
+	}
+}
+
+// Wrap returns a Writer handing the response to t, for middleware standing
+// between handlers and w. It answers the request w answers, so that HEAD is
+// handled the same on both, and shares its ClientConn.
+func (w *Writer) Wrap(t Transport) *Writer {
+	wrapped := NewTransportWriter(t)
+	wrapped.method = w.method
+	wrapped.client = w.client
+	return wrapped
+}
+
+// Flusher is implemented by Transports that hold on to body data, so that
+// Writer.Flush can push it to the client.
+type Flusher interface {
+	Flush() error
+}
+
+// Aborter is implemented by Transports that can cut a response off without
+// ending it, so that Writer.Abort doesn't leave the client with what looks
+// like a complete body.
+type Aborter interface {
+	Abort() error
+}
+
+// PassThrough is a Transport handing the response on to the Writer W as it
+// comes, framed the way its headers say. Middleware embeds it and overrides
+// the methods it needs, WriteHeader most of all, calling the embedded ones to
+// pass things on. Until its WriteHeader has run the rest is dropped, so that
+// a response can be swallowed by not passing its headers on.
+type PassThrough struct {
+	W *Writer
+
+	chunked bool
+	sent    bool
+}
+
+func (p *PassThrough) WriteHeader(statusCode StatusCode, h headers.Headers) error {
+	p.sent = true
+	p.chunked = h.IsChunked()
+	err := p.W.WriteStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+	return p.W.WriteHeaders(h)
+}
+
+func (p *PassThrough) WriteBody(b []byte) (int, error) {
+	switch {
+	case !p.sent:
+		return len(b), nil
+	case p.chunked:
+		return NewChunkWriter(p.W).Write(b)
+	}
+	return p.W.Write(b)
+}
+
+// WriteBodyFrom keeps a streamed body a reader all the way down, so files
+// still go out with sendfile.
+func (p *PassThrough) WriteBodyFrom(r io.Reader) (int64, error) {
+	switch {
+	case !p.sent:
+		return io.Copy(io.Discard, r)
+	case p.chunked:
+		return io.Copy(NewChunkWriter(p.W), r)
+	}
+	return p.W.WriteBodyFrom(r)
+}
+
+func (p *PassThrough) Finish(trailers headers.Headers) error {
+	switch {
+	case !p.sent:
+		return nil
+	case !p.chunked:
+		return p.W.Finish()
+	}
+	_, err := p.W.WriteChunkedbodyDone()
+	if err != nil {
+		return err
+	}
+	if trailers == nil {
+		trailers = headers.NewHeaders()
+	}
+	return p.W.WriteTrailers(trailers)
+}
+
+func (p *PassThrough) Abort() error {
+	if !p.sent {
+		return nil
+	}
+	return p.W.Abort()
+}
+
+func (p *PassThrough) Flush() error {
+	if !p.sent {
+		return nil
+	}
+	return p.W.Flush()
+}
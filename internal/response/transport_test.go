@@ -0,0 +1,67 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+)
+
+// tagger adds a header on the way through and passes the rest on.
+type tagger struct {
+	PassThrough
+	swallow bool
+}
+
+func (t *tagger) WriteHeader(statusCode StatusCode, h headers.Headers) error {
+	if t.swallow {
+		return nil
+	}
+	h.Set("X-Tagged", "yes")
+	return t.PassThrough.WriteHeader(statusCode, h)
+}
+
+func TestPassThrough(t *testing.T) {
+	// Test: Chunked bodies and trailers are passed on framed
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	inner := w.Wrap(&tagger{PassThrough: PassThrough{W: w}})
+	hdrs := headers.NewHeaders()
+	hdrs.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, inner.WriteStatusLine(StatusOK))
+	require.NoError(t, inner.WriteHeaders(hdrs))
+	_, err := inner.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = inner.WriteChunkedbodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Sum", "5")
+	require.NoError(t, inner.WriteTrailers(trailers))
+	assert.Contains(t, out.String(), "x-tagged: yes\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n5\r\nhello\r\n0\r\nx-sum: 5\r\n\r\n"))
+
+	// Test: HEAD carries over, and the length of the body is still counted
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetRequestMethod("HEAD")
+	inner = w.Wrap(&tagger{PassThrough: PassThrough{W: w}})
+	require.NoError(t, inner.WriteStatusLine(StatusOK))
+	require.NoError(t, inner.WriteHeaders(headers.NewHeaders()))
+	_, err = inner.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+
+	// Test: A response whose headers aren't passed on is dropped whole
+	out.Reset()
+	w = NewWriter(&out)
+	inner = w.Wrap(&tagger{PassThrough: PassThrough{W: w}, swallow: true})
+	require.NoError(t, inner.WriteStatusLine(StatusOK))
+	require.NoError(t, inner.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = inner.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// StatusError is implemented by errors that know the status code they have
+// to be answered with. Their message is shown to clients, so it must not
+// carry internal details.
+type StatusError interface {
+	error
+	HTTPStatus() int
+}
+
+// HandlerError is an error with the status code and message a client should
+// see. Err is the internal cause, which is logged but never sent. Header
+// holds headers the error response needs, like Allow for a 405.
+type HandlerError struct {
+	StatusCode response.StatusCode
+	Message    string
+	Err        error
+	Header     headers.Headers
+}
+
+// Error returns a HandlerError for status with a formatted message.
+func Error(status response.StatusCode, format string, args ...any) *HandlerError {
+	return &HandlerError{StatusCode: status, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *HandlerError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.message(), e.Err)
+	}
+	return e.message()
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HandlerError) HTTPStatus() int {
+	return int(e.StatusCode)
+}
+
+func (e *HandlerError) message() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return response.ReasonPhrase(e.StatusCode)
+}
+
+// ErrorHandler is a handler that reports failures by returning an error
+// instead of writing the error response itself. HandleErrors turns it into a
+// Handler.
+type ErrorHandler func(w *response.Writer, req *request.Request) error
+
+// HandleErrors runs h and answers the errors it returns with WriteError. If
+// h had already started its response, the error can only be logged.
+func HandleErrors(h ErrorHandler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+		if w.State() != response.StateWritingStatusLine || w.Hijacked() {
+			log.Printf("error after response started for %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			return
+		}
+		WriteError(w, req, err)
+	}
+}
+
+// StatusOf returns the status code err has to be answered with and the
+// message clients may see. Errors that don't say so themselves are 500s
+// with a generic message, since theirs can contain anything.
+func StatusOf(err error) (response.StatusCode, string) {
+	var se StatusError
+	switch {
+	case errors.As(err, &se):
+		status := response.StatusCode(se.HTTPStatus())
+		var he *HandlerError
+		if errors.As(err, &he) {
+			return status, he.message()
+		}
+		return status, se.Error()
+	case errors.Is(err, fs.ErrNotExist):
+		return response.StatusNotFound, response.ReasonPhrase(response.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		return response.StatusForbidden, response.ReasonPhrase(response.StatusForbidden)
+	}
+	return response.StatusInternalServerError, response.ReasonPhrase(response.StatusInternalServerError)
+}
+
+// WriteError answers err with an error page in the format the client
+// accepts: HTML, problem+json or plain text. req may be nil when the request
+// could not be parsed, which gets plain text. Server errors are logged.
+func WriteError(w *response.Writer, req *request.Request, err error) error {
+	status, message := StatusOf(err)
+	if status >= 500 {
+		log.Printf("internal error: %v", err)
+	}
+	var extra headers.Headers
+	var he *HandlerError
+	if errors.As(err, &he) {
+		extra = he.Header
+	}
+
+	accept := ""
+	if req != nil {
+		accept, _ = req.Headers.Get("accept")
+	}
+	contentType := response.NegotiateContentType(accept, "text/plain", "text/html", "application/json", "application/problem+json")
+
+	var body []byte
+	reason := response.ReasonPhrase(status)
+	switch contentType {
+	case "text/html":
+		body = []byte(fmt.Sprintf("<html>\n  <head>\n    <title>%d %s</title>\n  </head>\n  <body>\n    <h1>%s</h1>\n    <p>%s</p>\n  </body>\n</html>\n",
+			status, reason, reason, html.EscapeString(message)))
+	case "application/json", "application/problem+json":
+		contentType = "application/problem+json"
+		problem := response.Problem{Status: status, Title: reason}
+		if message != reason {
+			problem.Detail = message
+		}
+		body, err = json.Marshal(problem)
+		if err != nil {
+			return err
+		}
+	default:
+		contentType = "text/plain"
+		body = []byte(fmt.Sprintf("%d %s\n", status, reason))
+		if message != reason {
+			body = append(body, message+"\n"...)
+		}
+	}
+
+	h := response.GetDefaultHeaders(len(body))
+	for key, value := range extra {
+		h.ForceSet(key, value)
+	}
+	h.ForceSet("Content-Type", contentType)
+	if req != nil {
+		h.Set("Vary", "Accept")
+	}
+	err = w.WriteStatusLine(status)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	if req != nil && req.RequestLine.Method == "HEAD" {
+		return nil
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
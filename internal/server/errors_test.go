@@ -0,0 +1,115 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+func TestHandleErrors(t *testing.T) {
+	url := servertest.Start(t, server.HandleErrors(func(w *response.Writer, req *request.Request) error {
+		switch req.RequestLine.RequestTarget {
+		case "/typed":
+			return server.Error(response.StatusNotFound, "no widget <%d>", 7)
+		case "/missing":
+			return fmt.Errorf("open widget: %w", fs.ErrNotExist)
+		case "/json":
+			return &request.JSONError{Status: 415, Message: "Content-Type must be application/json"}
+		}
+		return errors.New("db password is hunter2")
+	}))
+
+	// Test: Browsers get an HTML page with the message escaped
+	resp, body := servertest.Do(t, nil, "GET", url+"/typed", "Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, "text/html", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+	assert.Contains(t, body, "<title>404 Not Found</title>")
+	assert.Contains(t, body, "no widget &lt;7&gt;")
+
+	// Test: API clients get problem details
+	resp, body = servertest.Do(t, nil, "GET", url+"/typed", "Accept", "application/json")
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	var problem map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &problem))
+	assert.Equal(t, map[string]any{"type": "about:blank", "title": "Not Found", "status": float64(404), "detail": "no widget <7>"}, problem)
+
+	// Test: Everyone else gets plain text
+	resp, body = servertest.Do(t, nil, "GET", url+"/typed")
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "404 Not Found\nno widget <7>\n", body)
+
+	// Test: Errors carrying their own status keep it, wrapped or not
+	resp, _ = servertest.Do(t, nil, "GET", url+"/json")
+	assert.Equal(t, 415, resp.StatusCode)
+	resp, body = servertest.Do(t, nil, "GET", url+"/missing")
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, "404 Not Found\n", body)
+
+	// Test: Untyped errors are 500s that don't leak their message
+	resp, body = servertest.Do(t, nil, "GET", url+"/other")
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Equal(t, "500 Internal Server Error\n", body)
+	assert.NotContains(t, body, "hunter2")
+}
+
+func TestHandleErrorsAfterWrite(t *testing.T) {
+	url := servertest.Start(t, server.HandleErrors(func(w *response.Writer, req *request.Request) error {
+		body := []byte("partial")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+		return errors.New("failed late")
+	}))
+
+	// Test: Errors after the response started don't add a second response
+	resp, body := servertest.Do(t, nil, "GET", url+"/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "partial", body)
+}
+
+func TestMalformedRequest(t *testing.T) {
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		t.Error("handler called for a malformed request")
+	})
+
+	tests := []struct {
+		name   string
+		raw    string
+		status string
+		body   string
+	}{
+		{"lowercase method", "get / HTTP/1.1\r\nHost: localhost\r\n\r\n", "400 Bad Request", "invalid method at offset 0"},
+		{"old version", "GET / HTTP/1.0\r\nHost: localhost\r\n\r\n", "505 HTTP Version Not Supported", "unsupported http version at offset 6"},
+		{"long target", "GET /" + strings.Repeat("a", request.MaxRequestLineBytes) + " HTTP/1.1\r\n\r\n", "414 URI Too Long", "request target too long"},
+		{"large body", "POST / HTTP/1.1\r\nContent-Length: 33554433\r\n\r\n", "413 Content Too Large", "request body too large at offset 45"},
+		{"large headers", "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", request.MaxHeaderBytes) + "\r\n\r\n", "431 Request Header Fields Too Large", "header section too large at offset 16"},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+		require.NoError(t, err)
+		_, err = conn.Write([]byte(tt.raw))
+		require.NoError(t, err)
+		raw, err := io.ReadAll(conn)
+		conn.Close()
+		require.NoError(t, err, tt.name)
+
+		// Test: Parse errors get their status and a message naming the
+		// problem, without parser internals
+		assert.Contains(t, string(raw), "HTTP/1.1 "+tt.status+"\r\n", tt.name)
+		assert.Contains(t, string(raw), tt.body, tt.name)
+		assert.NotContains(t, string(raw), "Error parsing request", tt.name)
+	}
+}
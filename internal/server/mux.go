@@ -0,0 +1,178 @@
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// Mux routes requests to handlers by path and method. A pattern is either
+// an exact path or, ending in "/", a prefix; exact paths win over prefixes
+// and longer prefixes over shorter ones, so "/" catches everything else.
+//
+// Routes registered for GET also answer HEAD, by running the GET handler
+// on a Writer that discards the body. OPTIONS is answered with the methods
+// of the route in Allow, and other methods with a 405 carrying the same
+// header. Routes registered without methods take every request themselves.
+//
+// Absolute-form targets ("GET http://host/path") are routed on their path,
+// and handlers see them in origin-form with the target's host as Host, the
+// way origin servers have to take them (RFC 9112 section 3.2.2).
+type Mux struct {
+	routes map[string]*route
+	// NotFound handles requests no route matches, a 404 when nil.
+	NotFound Handler
+}
+
+type route struct {
+	//handlers by method, "" for a route taking any method
+	handlers map[string]Handler
+}
+
+// NewMux returns a Mux without routes.
+func NewMux() *Mux {
+	return &Mux{routes: map[string]*route{}}
+}
+
+// Handle routes requests for pattern with one of methods to h, or all
+// requests for pattern when no methods are given. Registering the same
+// pattern again adds methods to it.
+func (m *Mux) Handle(pattern string, h Handler, methods ...string) {
+	rt, ok := m.routes[pattern]
+	if !ok {
+		rt = &route{handlers: map[string]Handler{}}
+		m.routes[pattern] = rt
+	}
+	if len(methods) == 0 {
+		rt.handlers[""] = h
+	}
+	for _, method := range methods {
+		rt.handlers[method] = h
+	}
+}
+
+// Handler serves req with the handler of the route it matches.
+func (m *Mux) Handler(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	target := req.RequestLine.RequestTarget
+
+	if method == "OPTIONS" && target == "*" {
+		writeAllow(w, m.allMethods())
+		return
+	}
+
+	if req.IsAbsoluteForm() {
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" {
+			WriteError(w, req, Error(response.StatusBadRequest, "invalid request target"))
+			return
+		}
+		target = u.RequestURI()
+		req.RequestLine.RequestTarget = target
+		req.Headers.ForceSet("host", u.Host)
+	}
+
+	path, _, _ := strings.Cut(target, "?")
+	rt := m.match(path)
+	if rt == nil {
+		if m.NotFound != nil {
+			m.NotFound(w, req)
+			return
+		}
+		WriteError(w, req, Error(response.StatusNotFound, "no such resource"))
+		return
+	}
+
+	if h, ok := rt.handlers[method]; ok {
+		h(w, req)
+		return
+	}
+	if h, ok := rt.handlers[""]; ok {
+		h(w, req)
+		return
+	}
+	if h, ok := rt.handlers["GET"]; ok && method == "HEAD" {
+		//the Writer knows the request is HEAD and drops the body, the
+		//handler answers it like GET so that the headers match
+		req.RequestLine.Method = "GET"
+		defer func() { req.RequestLine.Method = method }()
+		h(w, req)
+		return
+	}
+
+	allow := rt.methods()
+	if method == "OPTIONS" {
+		writeAllow(w, allow)
+		return
+	}
+	WriteError(w, req, &HandlerError{
+		StatusCode: response.StatusMethodNotAllowed,
+		Message:    method + " is not allowed here",
+		Header:     headers.Headers{"allow": strings.Join(allow, ", ")},
+	})
+}
+
+func (m *Mux) match(path string) *route {
+	if rt, ok := m.routes[path]; ok && !strings.HasSuffix(path, "/") {
+		return rt
+	}
+	best := ""
+	for pattern := range m.routes {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return m.routes[best]
+}
+
+// methods returns what a route allows: its methods, HEAD where GET is
+// allowed and OPTIONS.
+func (rt *route) methods() []string {
+	set := map[string]bool{"OPTIONS": true}
+	for method := range rt.handlers {
+		set[method] = true
+		if method == "GET" {
+			set["HEAD"] = true
+		}
+	}
+	return sortedMethods(set)
+}
+
+// allMethods returns the methods any route allows, for OPTIONS *.
+func (m *Mux) allMethods() []string {
+	set := map[string]bool{}
+	for _, rt := range m.routes {
+		for _, method := range rt.methods() {
+			set[method] = true
+		}
+	}
+	return sortedMethods(set)
+}
+
+func sortedMethods(set map[string]bool) []string {
+	methods := make([]string, 0, len(set))
+	for method := range set {
+		if method != "" {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func writeAllow(w *response.Writer, methods []string) {
+	h := headers.NewHeaders()
+	h.Set("Allow", strings.Join(methods, ", "))
+	h.Set("Content-Length", "0")
+	h.Set("Connection", "close")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	w.Finish()
+}
@@ -0,0 +1,116 @@
+package server_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+func textHandler(text string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		body := []byte(text + " " + req.RequestLine.Method)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func TestMux(t *testing.T) {
+	mux := server.NewMux()
+	mux.Handle("/", textHandler("root"), "GET")
+	mux.Handle("/api/", textHandler("api"), "GET")
+	mux.Handle("/api/items", textHandler("items"), "GET", "POST")
+	mux.Handle("/head", textHandler("explicit"), "GET", "HEAD")
+	mux.Handle("/proxy/", textHandler("proxy"))
+	url := servertest.Start(t, mux.Handler)
+
+	// Test: Exact paths win over prefixes, longer prefixes over shorter ones
+	_, body := servertest.Do(t, nil, "GET", url+"/api/items?limit=1")
+	assert.Equal(t, "items GET", body)
+	_, body = servertest.Do(t, nil, "GET", url+"/api/other")
+	assert.Equal(t, "api GET", body)
+	_, body = servertest.Do(t, nil, "GET", url+"/elsewhere")
+	assert.Equal(t, "root GET", body)
+
+	// Test: HEAD runs the GET handler and keeps its length, without a body
+	resp, body := servertest.Do(t, nil, "HEAD", url+"/api/items")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "9", resp.Header.Get("Content-Length"))
+	assert.Empty(t, body)
+
+	// Test: Routes handling HEAD themselves see it
+	resp, _ = servertest.Do(t, nil, "HEAD", url+"/head")
+	assert.Equal(t, "13", resp.Header.Get("Content-Length"))
+
+	// Test: OPTIONS lists the methods of the route
+	resp, body = servertest.Do(t, nil, "OPTIONS", url+"/api/items")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS, POST", resp.Header.Get("Allow"))
+	assert.Empty(t, body)
+
+	// Test: Other methods get a 405 with the same list
+	resp, _ = servertest.Do(t, nil, "DELETE", url+"/api/items")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS, POST", resp.Header.Get("Allow"))
+
+	// Test: Routes without methods take every request
+	_, body = servertest.Do(t, nil, "DELETE", url+"/proxy/x")
+	assert.Equal(t, "proxy DELETE", body)
+	_, body = servertest.Do(t, nil, "OPTIONS", url+"/proxy/x")
+	assert.Equal(t, "proxy OPTIONS", body)
+}
+
+func TestMuxOptionsStar(t *testing.T) {
+	mux := server.NewMux()
+	mux.Handle("/a", textHandler("a"), "GET")
+	mux.Handle("/b", textHandler("b"), "PUT")
+	var out bytes.Buffer
+	mux.Handler(response.NewWriter(&out), &request.Request{RequestLine: request.RequestLine{Method: "OPTIONS", RequestTarget: "*", HttpVersion: "1.1"}})
+
+	// Test: OPTIONS * lists what any route allows
+	assert.Contains(t, out.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, out.String(), "allow: GET, HEAD, OPTIONS, PUT\r\n")
+}
+
+func TestMuxNotFound(t *testing.T) {
+	mux := server.NewMux()
+	mux.Handle("/a", textHandler("a"), "GET")
+	url := servertest.Start(t, mux.Handler)
+
+	// Test: Unmatched paths are 404s
+	resp, body := servertest.Do(t, nil, "GET", url+"/b")
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(body, "404 Not Found"))
+
+	// Test: NotFound replaces the default 404
+	mux.NotFound = textHandler("missing")
+	_, body = servertest.Do(t, nil, "GET", url+"/b")
+	assert.Equal(t, "missing GET", body)
+}
+
+func TestMuxAbsoluteForm(t *testing.T) {
+	mux := server.NewMux()
+	mux.Handle("/api/items", textHandler("items"), "GET")
+	var out bytes.Buffer
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "http://example.com/api/items?limit=1", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	mux.Handler(response.NewWriter(&out), req)
+
+	// Test: Absolute-form targets are routed on their path
+	assert.Contains(t, out.String(), "HTTP/1.1 200 OK\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "items GET"))
+
+	// Test: Handlers see them in origin-form, with the target's host
+	assert.Equal(t, "/api/items?limit=1", req.RequestLine.RequestTarget)
+	host, _ := req.Headers.Get("host")
+	assert.Equal(t, "example.com", host)
+}
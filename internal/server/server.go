@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"net"
 	"sync/atomic"
 
+	"www.github.com/isaac-albert/httpfromtcp/internal/http2"
 	"www.github.com/isaac-albert/httpfromtcp/internal/request"
 	"www.github.com/isaac-albert/httpfromtcp/internal/response"
 )
@@ -16,25 +18,11 @@ import (
 
 type Handler func(w *response.Writer, req *request.Request)
 
-type HandlerError struct {
-	StatusCode response.StatusCode
-	Message    string
-}
-
-// func (h *HandlerError) Write(w *response.Writer) {
-
-// 	msg := []byte(h.Message)
-
-// 	w.WriteStatusLine(h.StatusCode)
-// 	hdrs := response.GetDefaultHeaders(len(msg))
-// 	w.WriteHeaders(hdrs)
-// 	w.WriteBody(msg)
-// }
-
 type Server struct {
 	Listener net.Listener
 	handler  Handler
 	isClosed atomic.Bool
+	name     atomic.Pointer[string]
 }
 
 func NewServer() *Server {
@@ -84,19 +72,76 @@ func (s *Server) Listen() {
 
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+	br := bufio.NewReader(conn)
+	if http2.HasPreface(br) {
+		//HTTP/2 with prior knowledge
+		http2.ServeConn(conn, br, http2.Handler(s.serve))
+		return
+	}
 
-	w := response.NewWriter(conn)
+	req, buffered, err := request.ReadRequest(br)
+	if br.Buffered() > 0 {
+		rest, _ := br.Peek(br.Buffered())
+		buffered = append(buffered, rest...)
+	}
+
+	w := response.NewConnWriter(conn, buffered)
+	defer func() {
+		if !w.Hijacked() {
+			conn.Close()
+		}
+	}()
 
-	req, err := request.RequestFromReader(conn)
 	if err != nil {
-		log.Printf("code is not able to parse from request")
-		w.WriteStatusLine(response.StatusBadRequest)
-		body := []byte(fmt.Sprintf("Error parsing request: %v", err))
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+		//only requests that were read and found malformed get an answer,
+		//when reading failed there is nobody left to answer
+		var parseErr *request.ParseError
+		if errors.As(err, &parseErr) {
+			log.Printf("could not parse request from %s: %v", conn.RemoteAddr(), err)
+			if name := s.name.Load(); name != nil {
+				w.SetServerName(*name)
+			}
+			WriteError(w, nil, parseErr)
+		} else if !errors.Is(err, io.EOF) {
+			log.Printf("could not read request from %s: %v", conn.RemoteAddr(), err)
+		}
 		return
 	}
+	req.RemoteAddr = conn.RemoteAddr().String()
+	w.SetRequestMethod(req.RequestLine.Method)
+
+	if http2.IsUpgradeRequest(req) {
+		err = http2.Upgrade(w, req, http2.Handler(s.serve))
+		if err == nil {
+			return
+		}
+		log.Printf("h2c upgrade failed: %v", err)
+		if w.Hijacked() {
+			return
+		}
+	}
+	s.serve(w, req)
+
+	//end a body the handler left open, so a chunked response is complete
+	if w.State() == response.StateWritingBody || w.State() == response.StateWritingTrailers {
+		err = w.Finish()
+		if err != nil {
+			log.Printf("incomplete response to %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		}
+	}
+}
+
+// SetName sets the product token sent in the Server header of responses
+// that don't set their own. By default no Server header is sent.
+func (s *Server) SetName(name string) {
+	s.name.Store(&name)
+}
+
+// serve runs the handler with the server's defaults applied to w.
+func (s *Server) serve(w *response.Writer, req *request.Request) {
+	if name := s.name.Load(); name != nil {
+		w.SetServerName(*name)
+	}
 	s.handler(w, req)
 }
 
@@ -0,0 +1,205 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/http2"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+func TestHijack(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		require.NoError(t, err)
+
+		// Test: Writing through the response writer fails after hijacking
+		err = w.WriteStatusLine(response.StatusOK)
+		assert.Error(t, err)
+		_, _, err = w.Hijack()
+		assert.Error(t, err)
+
+		// echo lines back, starting with the bytes that came with the request
+		go func() {
+			reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+			fmt.Fprintf(conn, "custom protocol\n")
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "echo: %s", line)
+			}
+		}()
+		hijacked <- conn
+	})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the first protocol line is sent together with the request
+	_, err = conn.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: localhost\r\n\r\nfirst\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "custom protocol\n", line)
+
+	// Test: Buffered bytes are handed over with the connection
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: first\n", line)
+
+	// Test: The server does not close the connection once the handler returns
+	serverConn := <-hijacked
+	defer serverConn.Close()
+	time.Sleep(10 * time.Millisecond)
+	_, err = conn.Write([]byte("second\n"))
+	require.NoError(t, err)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: second\n", line)
+}
+
+func TestNoHijack(t *testing.T) {
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		body := []byte("done")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: The connection is closed after the handler returns
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "HTTP/1.1 200 OK\r\n")
+}
+
+func TestH2C(t *testing.T) {
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		body := []byte(fmt.Sprintf("%s %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+
+	// Test: Prior knowledge is detected from the client preface
+	resp, body := servertest.Get(t, servertest.H2CClient(), url+"/prior")
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, "GET /prior 2", body)
+
+	// Test: Plain HTTP/1.1 requests are unaffected
+	resp, body = servertest.Get(t, nil, url+"/plain")
+	assert.Equal(t, "HTTP/1.1", resp.Proto)
+	assert.Equal(t, "GET /plain 1.1", body)
+
+	// Test: Upgrade from HTTP/1.1, with the request answered on stream 1
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /upgraded HTTP/1.1\r\nHost: localhost\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAQAAP__\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	var head strings.Builder
+	for !strings.HasSuffix(head.String(), "\r\n\r\n") {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		head.WriteString(line)
+	}
+	assert.True(t, strings.HasPrefix(head.String(), "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, strings.ToLower(head.String()), "upgrade: h2c\r\n")
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, &http2.Frame{Type: http2.FrameSettings}))
+
+	decoder := http2.NewDecoder(4096)
+	var status string
+	var data []byte
+	for {
+		f, err := http2.ReadFrame(reader, 1<<24-1)
+		require.NoError(t, err)
+		if f.StreamID != 1 {
+			continue
+		}
+		if f.Type == http2.FrameHeaders {
+			fields, err := decoder.Decode(f.Payload)
+			require.NoError(t, err)
+			status = fields[0].Value
+		}
+		if f.Type == http2.FrameData {
+			data = append(data, f.Payload...)
+		}
+		if f.Has(http2.FlagEndStream) {
+			break
+		}
+	}
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "GET /upgraded 2", string(data))
+}
+
+func TestUnfinishedBody(t *testing.T) {
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"content-type": "text/plain"})
+		w.Write([]byte("streamed "))
+		w.Flush()
+		w.Write([]byte("body"))
+	})
+
+	// Test: A flushed body without a length is chunked, and ended by the
+	// server when the handler returns without finishing it
+	resp, err := http.Get(url + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "streamed body", string(body))
+}
+
+func TestServerName(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"content-type": "text/plain"})
+		w.WriteBody([]byte("hello"))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	s.SetName("httpfromtcp")
+
+	// Test: Responses carry the server's name, a Date and a computed
+	// Content-Length
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", s.Listener.Addr().(*net.TCPAddr).Port))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "httpfromtcp", resp.Header.Get("Server"))
+	assert.NotEmpty(t, resp.Header.Get("Date"))
+	assert.Equal(t, int64(5), resp.ContentLength)
+}
@@ -0,0 +1,59 @@
+// Package servertest runs servers on local ports for the tests of packages
+// built on top of server, and reads their responses.
+package servertest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+)
+
+// Start serves handler on a random local port until the test ends and
+// returns its base url, "http://127.0.0.1:<port>".
+func Start(t testing.TB, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return fmt.Sprintf("http://127.0.0.1:%d", s.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Do sends a request with the given header name and value pairs through
+// client, http.DefaultClient when nil, and returns the response with its
+// body read.
+func Do(t testing.TB, client *http.Client, method, url string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+// Get is Do for a GET without extra headers.
+func Get(t testing.TB, client *http.Client, url string) (*http.Response, string) {
+	t.Helper()
+	return Do(t, client, "GET", url)
+}
+
+// H2CClient returns a client speaking HTTP/2 with prior knowledge over
+// cleartext connections, as the server accepts.
+func H2CClient() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: protocols}}
+}
@@ -0,0 +1,131 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MinKeyLength is the shortest key NewSigner accepts.
+const MinKeyLength = 32
+
+var ErrInvalidSignature = errors.New("invalid cookie signature")
+var ErrDecrypt = errors.New("cookie could not be decrypted")
+
+// Signer signs cookie values with HMAC-SHA256 so clients can read but not
+// change them. The first key signs; all keys verify, so keys can be rotated
+// by putting a new one in front and dropping the oldest once the cookies it
+// signed have expired.
+type Signer struct {
+	keys [][]byte
+}
+
+func NewSigner(keys ...[]byte) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("signer needs at least one key")
+	}
+	for _, key := range keys {
+		if len(key) < MinKeyLength {
+			return nil, fmt.Errorf("signing keys have to be at least %d bytes", MinKeyLength)
+		}
+	}
+	return &Signer{keys: keys}, nil
+}
+
+// Sign returns value with its signature appended. The cookie name is signed
+// too, so a value can't be moved to another cookie.
+func (s *Signer) Sign(name, value string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(s.keys[0], name, encoded))
+}
+
+// Verify returns the value signed by Sign, if any of the keys signed it.
+func (s *Signer) Verify(name, signed string) (string, error) {
+	encoded, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return "", ErrInvalidSignature
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	for _, key := range s.keys {
+		if hmac.Equal(mac, s.mac(key, name, encoded)) {
+			value, err := base64.RawURLEncoding.DecodeString(encoded)
+			if err != nil {
+				return "", ErrInvalidSignature
+			}
+			return string(value), nil
+		}
+	}
+	return "", ErrInvalidSignature
+}
+
+func (s *Signer) mac(key []byte, name, encoded string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name + "|" + encoded))
+	return h.Sum(nil)
+}
+
+// Cipher encrypts cookie values with AES-GCM, so clients can neither read
+// nor change them. Keys are 16, 24 or 32 bytes and rotate like Signer keys:
+// the first encrypts, all decrypt.
+type Cipher struct {
+	aeads []cipher.AEAD
+}
+
+func NewCipher(keys ...[]byte) (*Cipher, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("cipher needs at least one key")
+	}
+	c := &Cipher{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Encrypt seals plaintext with a random nonce, binding it to the cookie
+// name.
+func (c *Cipher) Encrypt(name string, plaintext []byte) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *Cipher) Decrypt(name, value string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrDecrypt
+}
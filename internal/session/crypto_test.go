@@ -0,0 +1,74 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner(t *testing.T) {
+	_, err := NewSigner([]byte("short"))
+	require.Error(t, err)
+	_, err = NewSigner()
+	require.Error(t, err)
+
+	s, err := NewSigner(key1)
+	require.NoError(t, err)
+	signed := s.Sign("name", "value")
+
+	// Test: Signed values verify under the same cookie name only
+	value, err := s.Verify("name", signed)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	_, err = s.Verify("other", signed)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = s.Verify("name", "dmFsdWU")
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// Test: A rotated signer still verifies the old key, and signs with the
+	// new one
+	rotated, err := NewSigner(key2, key1)
+	require.NoError(t, err)
+	value, err = rotated.Verify("name", signed)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	_, err = s.Verify("name", rotated.Sign("name", "value"))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestCipher(t *testing.T) {
+	_, err := NewCipher([]byte("not an aes key"))
+	require.Error(t, err)
+
+	c, err := NewCipher(key1)
+	require.NoError(t, err)
+	sealed, err := c.Encrypt("name", []byte("secret"))
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "secret")
+
+	// Test: Every encryption uses a fresh nonce
+	again, err := c.Encrypt("name", []byte("secret"))
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	// Test: Decryption needs the same name and untouched ciphertext
+	plain, err := c.Decrypt("name", sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plain))
+	_, err = c.Decrypt("other", sealed)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	tampered := []byte(sealed)
+	tampered[len(tampered)-1] ^= 1
+	_, err = c.Decrypt("name", string(tampered))
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = c.Decrypt("name", "AAAA")
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	// Test: Rotated keys still decrypt
+	rotated, err := NewCipher(key2[:16], key1)
+	require.NoError(t, err)
+	plain, err = rotated.Decrypt("name", sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plain))
+}
@@ -0,0 +1,395 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/cookie"
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+)
+
+const (
+	DefaultCookieName = "session"
+	DefaultMaxAge     = 24 * time.Hour
+	// MaxCookieSize is the most browsers are guaranteed to keep of a cookie,
+	// which limits how much an encrypted cookie session can hold.
+	MaxCookieSize = 4096
+)
+
+const idLength = 32
+
+// Options configure the session cookie. Sessions are HttpOnly and expire
+// MaxAge after they were last changed.
+type Options struct {
+	CookieName string
+	Path       string
+	Domain     string
+	MaxAge     time.Duration
+	Secure     bool
+	SameSite   cookie.SameSite
+}
+
+// Session holds the values of one client's session. It is only used by the
+// handler serving the request, and changes are saved when the response
+// headers are written.
+type Session struct {
+	ID string
+
+	values    map[string]string
+	isNew     bool
+	modified  bool
+	destroyed bool
+	//oldID is the ID replaced by Regenerate, deleted from the store on save
+	oldID string
+}
+
+func newSession() (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, values: map[string]string{}, isNew: true}, nil
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *Session) Set(key, value string) {
+	s.values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// IsNew reports whether the client had no valid session before this request.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Regenerate gives the session a new ID and keeps its values. Call it when
+// the privileges of the session change, like on login, so an ID an attacker
+// planted or learned before is worthless afterwards.
+func (s *Session) Regenerate() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if !s.isNew && s.oldID == "" {
+		s.oldID = s.ID
+	}
+	s.ID = id
+	s.modified = true
+	return nil
+}
+
+// Destroy removes the session from the store and the client.
+func (s *Session) Destroy() {
+	s.values = map[string]string{}
+	s.destroyed = true
+}
+
+// Manager loads and saves sessions around handlers. Sessions live either in
+// a Store, with the client holding a signed session ID, or entirely in an
+// encrypted cookie.
+type Manager struct {
+	Options
+
+	store  Store
+	signer *Signer
+	cipher *Cipher
+
+	mu       sync.Mutex
+	sessions map[*request.Request]*Session
+	now      func() time.Time
+}
+
+// NewManager returns a Manager keeping sessions in store. Session IDs are
+// signed with keys, see Signer.
+func NewManager(store Store, opts Options, keys ...[]byte) (*Manager, error) {
+	signer, err := NewSigner(keys...)
+	if err != nil {
+		return nil, err
+	}
+	m := newManager(opts)
+	m.store = store
+	m.signer = signer
+	return m, nil
+}
+
+// NewCookieManager returns a Manager keeping the whole session in a cookie
+// encrypted with keys, see Cipher. Nothing is stored on the server, but
+// sessions have to fit in MaxCookieSize and a destroyed session's cookie
+// stays valid until it expires if a client keeps a copy.
+func NewCookieManager(opts Options, keys ...[]byte) (*Manager, error) {
+	c, err := NewCipher(keys...)
+	if err != nil {
+		return nil, err
+	}
+	m := newManager(opts)
+	m.cipher = c
+	return m, nil
+}
+
+func newManager(opts Options) *Manager {
+	if opts.CookieName == "" {
+		opts.CookieName = DefaultCookieName
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = DefaultMaxAge
+	}
+	if opts.SameSite == cookie.SameSiteDefault {
+		opts.SameSite = cookie.SameSiteLax
+	}
+	return &Manager{
+		Options:  opts,
+		sessions: make(map[*request.Request]*Session),
+		now:      time.Now,
+	}
+}
+
+// Get returns the session of a request served through Handler, or nil.
+func (m *Manager) Get(req *request.Request) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[req]
+}
+
+// Handler makes the client's session available to next through Get. The
+// session is saved, and its cookie set, when next writes the response
+// headers, and only if the session was changed.
+func (m *Manager) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s, err := m.load(req)
+		if err != nil {
+			log.Printf("error loading session: %v", err)
+			writeError(w)
+			return
+		}
+		m.mu.Lock()
+		m.sessions[req] = s
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.sessions, req)
+			m.mu.Unlock()
+		}()
+
+		//a wrapped Writer can't be hijacked; the session can still be read
+		if _, ok := req.Headers.Get("upgrade"); ok || req.RequestLine.Method == "CONNECT" {
+			next(w, req)
+			return
+		}
+
+		sw := &sessionWriter{PassThrough: response.PassThrough{W: w}, m: m, s: s}
+		next(w.Wrap(sw), req)
+		if !sw.committed {
+			//no response headers to add a cookie to, but the store can still
+			//be brought up to date
+			err := m.commit(s, nil)
+			if err != nil {
+				log.Printf("error saving session: %v", err)
+			}
+		}
+	}
+}
+
+// load returns the session the request's cookie refers to, or a new one.
+// IDs a client makes up are never adopted.
+func (m *Manager) load(req *request.Request) (*Session, error) {
+	c, err := cookie.Get(req, m.CookieName)
+	if err != nil {
+		return newSession()
+	}
+
+	if m.cipher != nil {
+		s, ok := m.decode(c.Value)
+		if !ok {
+			return newSession()
+		}
+		return s, nil
+	}
+
+	id, err := m.signer.Verify(m.CookieName, c.Value)
+	if err != nil || !validID(id) {
+		return newSession()
+	}
+	values, ok, err := m.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return newSession()
+	}
+	return &Session{ID: id, values: values}, nil
+}
+
+// commit saves a changed session and adds its cookie to h, if any.
+func (m *Manager) commit(s *Session, h headers.Headers) error {
+	if s.destroyed {
+		if m.store != nil {
+			for _, id := range []string{s.oldID, s.ID} {
+				if id == "" {
+					continue
+				}
+				err := m.store.Delete(id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if s.isNew || h == nil {
+			return nil
+		}
+		return cookie.Set(h, m.cookie("", -1))
+	}
+	if !s.modified {
+		return nil
+	}
+
+	var value string
+	if m.cipher != nil {
+		var err error
+		value, err = m.encode(s)
+		if err != nil {
+			return err
+		}
+	} else {
+		if s.oldID != "" {
+			err := m.store.Delete(s.oldID)
+			if err != nil {
+				return err
+			}
+		}
+		err := m.store.Save(s.ID, s.values, m.MaxAge)
+		if err != nil {
+			return err
+		}
+		value = m.signer.Sign(m.CookieName, s.ID)
+	}
+	if h == nil {
+		return nil
+	}
+	return cookie.Set(h, m.cookie(value, int(m.MaxAge.Seconds())))
+}
+
+func (m *Manager) cookie(value string, maxAge int) *cookie.Cookie {
+	return &cookie.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	}
+}
+
+type cookieSession struct {
+	ID      string            `json:"id"`
+	Values  map[string]string `json:"values"`
+	Expires int64             `json:"expires"`
+}
+
+func (m *Manager) encode(s *Session) (string, error) {
+	data, err := json.Marshal(cookieSession{
+		ID:      s.ID,
+		Values:  s.values,
+		Expires: m.now().Add(m.MaxAge).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	value, err := m.cipher.Encrypt(m.CookieName, data)
+	if err != nil {
+		return "", err
+	}
+	if len(m.CookieName)+1+len(value) > MaxCookieSize {
+		return "", fmt.Errorf("session too large for a cookie")
+	}
+	return value, nil
+}
+
+// decode opens a cookie session. The expiry inside is checked as well as
+// the cookie's, since clients don't have to honor Max-Age.
+func (m *Manager) decode(value string) (*Session, bool) {
+	data, err := m.cipher.Decrypt(m.CookieName, value)
+	if err != nil {
+		return nil, false
+	}
+	var cs cookieSession
+	err = json.Unmarshal(data, &cs)
+	if err != nil || m.now().Unix() >= cs.Expires {
+		return nil, false
+	}
+	if cs.Values == nil {
+		cs.Values = map[string]string{}
+	}
+	return &Session{ID: cs.ID, values: cs.Values}, true
+}
+
+func newID() (string, error) {
+	b := make([]byte, idLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	if len(id) != base64.RawURLEncoding.EncodedLen(idLength) {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeError(w *response.Writer) {
+	body := []byte(response.ReasonPhrase(response.StatusInternalServerError))
+	w.WriteStatusLine(response.StatusInternalServerError)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// sessionWriter is the Transport handlers write to. It saves the session
+// and adds its cookie when the headers come through, then passes the
+// response on unchanged.
+type sessionWriter struct {
+	response.PassThrough
+	m         *Manager
+	s         *Session
+	committed bool
+}
+
+func (sw *sessionWriter) WriteHeader(statusCode response.StatusCode, h headers.Headers) error {
+	sw.committed = true
+	err := sw.m.commit(sw.s, h)
+	if err != nil {
+		log.Printf("error saving session: %v", err)
+	}
+	return sw.PassThrough.WriteHeader(statusCode, h)
+}
@@ -0,0 +1,187 @@
+package session
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/server"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+var (
+	key1 = []byte(strings.Repeat("k", 32))
+	key2 = []byte(strings.Repeat("n", 32))
+)
+
+// app counts visits in the session and lets the client log in, which
+// regenerates the session ID, or log out.
+func app(m *Manager) server.Handler {
+	return m.Handler(func(w *response.Writer, req *request.Request) {
+		s := m.Get(req)
+		switch req.RequestLine.RequestTarget {
+		case "/login":
+			s.Regenerate()
+			s.Set("user", "alice")
+		case "/logout":
+			s.Destroy()
+		case "/visit":
+			visits, _ := s.Get("visits")
+			s.Set("visits", visits+"x")
+		}
+		user, _ := s.Get("user")
+		visits, _ := s.Get("visits")
+		body := []byte(fmt.Sprintf("user=%s visits=%d new=%v", user, len(visits), s.IsNew()))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+}
+
+func startApp(t *testing.T, m *Manager) (string, *http.Client) {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	return servertest.Start(t, app(m)), &http.Client{Jar: jar}
+}
+
+func sessionCookie(t *testing.T, resp *http.Response) *http.Cookie {
+	t.Helper()
+	for _, c := range resp.Cookies() {
+		if c.Name == DefaultCookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestStoreSessions(t *testing.T) {
+	store := NewMemoryStore(0)
+	defer store.Close()
+	m, err := NewManager(store, Options{}, key1)
+	require.NoError(t, err)
+	base, client := startApp(t, m)
+
+	// Test: Unchanged sessions set no cookie and store nothing
+	resp, body := servertest.Get(t, client, base+"/")
+	assert.Nil(t, sessionCookie(t, resp))
+	assert.Equal(t, "user= visits=0 new=true", body)
+	assert.Equal(t, 0, store.Len())
+
+	// Test: A changed session is stored and its signed ID set in a cookie
+	resp, _ = servertest.Get(t, client, base+"/visit")
+	c := sessionCookie(t, resp)
+	require.NotNil(t, c)
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+	assert.Equal(t, int(DefaultMaxAge.Seconds()), c.MaxAge)
+	assert.Equal(t, 1, store.Len())
+	_, body = servertest.Get(t, client, base+"/visit")
+	assert.Equal(t, "user= visits=2 new=false", body)
+
+	// Test: Logging in moves the session to a new ID and drops the old one
+	resp, body = servertest.Get(t, client, base+"/login")
+	assert.Equal(t, "user=alice visits=2 new=false", body)
+	login := sessionCookie(t, resp)
+	require.NotNil(t, login)
+	assert.NotEqual(t, c.Value, login.Value)
+	assert.Equal(t, 1, store.Len())
+	req, _ := http.NewRequest("GET", base+"/", nil)
+	req.AddCookie(c)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	old, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "user= visits=0 new=true", string(old))
+
+	// Test: Logging out deletes the session and the cookie
+	resp, _ = servertest.Get(t, client, base+"/logout")
+	c = sessionCookie(t, resp)
+	require.NotNil(t, c)
+	assert.Equal(t, -1, c.MaxAge)
+	assert.Equal(t, 0, store.Len())
+	_, body = servertest.Get(t, client, base+"/")
+	assert.Equal(t, "user= visits=0 new=true", body)
+}
+
+func TestForgedCookies(t *testing.T) {
+	store := NewMemoryStore(0)
+	defer store.Close()
+	m, err := NewManager(store, Options{}, key1)
+	require.NoError(t, err)
+	base, client := startApp(t, m)
+	resp, _ := servertest.Get(t, client, base+"/visit")
+	real := sessionCookie(t, resp)
+
+	request := func(value string) string {
+		req, _ := http.NewRequest("GET", base+"/", nil)
+		req.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: value})
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// Test: The genuine cookie works, unsigned, tampered and made up IDs
+	// start a new session
+	assert.Equal(t, "user= visits=1 new=false", request(real.Value))
+	id, _, _ := strings.Cut(real.Value, ".")
+	assert.Equal(t, "user= visits=0 new=true", request(id))
+	assert.Equal(t, "user= visits=0 new=true", request(id+"."+strings.Repeat("A", 43)))
+	other, _ := NewSigner(key2)
+	assert.Equal(t, "user= visits=0 new=true", request(other.Sign(DefaultCookieName, "attacker-chosen-id")))
+}
+
+func TestCookieSessions(t *testing.T) {
+	m, err := NewCookieManager(Options{CookieName: "sid", Secure: true}, key1)
+	require.NoError(t, err)
+	base, client := startApp(t, m)
+
+	// Test: The session travels in the cookie, encrypted
+	resp, _ := servertest.Get(t, client, base+"/login")
+	var c *http.Cookie
+	for _, rc := range resp.Cookies() {
+		if rc.Name == "sid" {
+			c = rc
+		}
+	}
+	require.NotNil(t, c)
+	assert.True(t, c.Secure)
+	assert.NotContains(t, c.Value, "alice")
+
+	req, _ := http.NewRequest("GET", base+"/visit", nil)
+	req.AddCookie(c)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "user=alice visits=1 new=false", string(body))
+
+	// Test: After rotating keys, old cookies still open
+	rotated, err := NewCookieManager(Options{CookieName: "sid"}, key2, key1)
+	require.NoError(t, err)
+	s, ok := rotated.decode(c.Value)
+	require.True(t, ok)
+	user, _ := s.Get("user")
+	assert.Equal(t, "alice", user)
+
+	// Test: Expired cookie sessions are refused even if the client kept them
+	m.now = func() time.Time { return time.Now().Add(DefaultMaxAge + time.Minute) }
+	_, ok = m.decode(c.Value)
+	assert.False(t, ok)
+
+	// Test: Sessions that don't fit in a cookie are not saved
+	s, _ = newSession()
+	s.Set("big", strings.Repeat("x", MaxCookieSize))
+	_, err = m.encode(s)
+	require.Error(t, err)
+}
@@ -0,0 +1,233 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps session values on the server, keyed by session ID.
+type Store interface {
+	// Load returns the values of a session, or ok false when there is no
+	// such session or it has expired.
+	Load(id string) (values map[string]string, ok bool, err error)
+	// Save stores values under id for ttl from now.
+	Save(id string, values map[string]string, ttl time.Duration) error
+	Delete(id string) error
+}
+
+type memoryEntry struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// MemoryStore keeps sessions in memory. Expired sessions are never returned
+// and are removed by a sweep once per cleanup interval.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+	now      func() time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMemoryStore returns a MemoryStore sweeping expired sessions every
+// cleanupInterval until Close is called. A zero interval never sweeps.
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		sessions: make(map[string]memoryEntry),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+	if cleanupInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(cleanupInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.stop:
+					return
+				case <-ticker.C:
+					s.sweep()
+				}
+			}
+		}()
+	}
+	return s
+}
+
+func (s *MemoryStore) Load(id string) (map[string]string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.sessions[id]
+	if !ok || !s.now().Before(e.expires) {
+		return nil, false, nil
+	}
+	return copyValues(e.values), true, nil
+}
+
+func (s *MemoryStore) Save(id string, values map[string]string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[id] = memoryEntry{values: copyValues(values), expires: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// Len returns the number of sessions held, expired ones not swept yet
+// included.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func (s *MemoryStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for id, e := range s.sessions {
+		if !now.Before(e.expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *MemoryStore) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// FileStore keeps each session as a JSON file in Dir, so sessions survive
+// restarts.
+type FileStore struct {
+	Dir string
+	now func() time.Time
+}
+
+type fileSession struct {
+	Values  map[string]string `json:"values"`
+	Expires time.Time         `json:"expires"`
+}
+
+// NewFileStore returns a FileStore in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{Dir: dir, now: time.Now}, nil
+}
+
+func (s *FileStore) Load(id string) (map[string]string, bool, error) {
+	name, err := s.path(id)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var fs fileSession
+	err = json.Unmarshal(data, &fs)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading session file: %w", err)
+	}
+	if !s.now().Before(fs.Expires) {
+		os.Remove(name)
+		return nil, false, nil
+	}
+	return fs.Values, true, nil
+}
+
+// Save writes the session to a temporary file first and renames it into
+// place, so readers never see a half written session.
+func (s *FileStore) Save(id string, values map[string]string, ttl time.Duration) error {
+	name, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fileSession{Values: values, Expires: s.now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.Dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *FileStore) Delete(id string) error {
+	name, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Cleanup removes the files of expired sessions.
+func (s *FileStore) Cleanup() error {
+	names, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		id := strings.TrimSuffix(filepath.Base(name), ".json")
+		_, _, err := s.Load(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path maps id to its file, refusing IDs that could point elsewhere.
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", fmt.Errorf("invalid session id")
+	}
+	return filepath.Join(s.Dir, id+".json"), nil
+}
+
+func copyValues(values map[string]string) map[string]string {
+	c := make(map[string]string, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewMemoryStore(0)
+	defer s.Close()
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Save("a", map[string]string{"k": "v"}, time.Minute))
+	require.NoError(t, s.Save("b", map[string]string{}, time.Hour))
+
+	// Test: Stored values are copies
+	values, ok, err := s.Load("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	values["k"] = "changed"
+	values, _, _ = s.Load("a")
+	assert.Equal(t, "v", values["k"])
+
+	// Test: Expired sessions are not returned, and swept
+	now = now.Add(2 * time.Minute)
+	_, ok, _ = s.Load("a")
+	assert.False(t, ok)
+	assert.Equal(t, 2, s.Len())
+	s.sweep()
+	assert.Equal(t, 1, s.Len())
+
+	require.NoError(t, s.Delete("b"))
+	_, ok, _ = s.Load("b")
+	assert.False(t, ok)
+}
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	s, err := NewFileStore(dir)
+	require.NoError(t, err)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	id, _ := newID()
+	other, _ := newID()
+
+	// Test: Sessions are written to files and read back
+	require.NoError(t, s.Save(id, map[string]string{"user": "alice"}, time.Minute))
+	require.NoError(t, s.Save(other, map[string]string{}, time.Hour))
+	values, ok, err := s.Load(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "alice", values["user"])
+	_, err = os.Stat(filepath.Join(dir, id+".json"))
+	require.NoError(t, err)
+
+	// Test: IDs can't reach outside the directory
+	_, _, err = s.Load("../../etc/passwd")
+	require.Error(t, err)
+	require.Error(t, s.Save("../x", nil, time.Minute))
+
+	// Test: Cleanup removes expired sessions only
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, s.Cleanup())
+	_, err = os.Stat(filepath.Join(dir, id+".json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, ok, _ = s.Load(other)
+	assert.True(t, ok)
+
+	require.NoError(t, s.Delete(other))
+	require.NoError(t, s.Delete(other))
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,273 @@
+package sse
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// Event is a single server-sent event. Empty fields are left out.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// Encode formats the event in the text/event-stream format. Multi-line data
+// is split over several data fields, as the client joins them back with "\n".
+func (e Event) Encode() ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("event id cannot contain newlines or NUL")
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("event name cannot contain newlines")
+	}
+
+	var sb strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&sb, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&sb, "event: %s\n", e.Event)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&sb, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&sb, "data: %s\n", line)
+	}
+	sb.WriteString("\n")
+	return []byte(sb.String()), nil
+}
+
+// Writer streams events to a client over a chunked response. Every event is
+// written to the connection as its own chunk, so it reaches the client
+// immediately. Writer is safe for concurrent use.
+type Writer struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu       sync.Mutex
+	closed   bool
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// NewWriter sends the response head for an event stream. The Last-Event-ID
+// sent by a reconnecting client is available through LastEventID. Over
+// HTTP/1.1 it then reads the connection until the client hangs up, which
+// closes Done.
+func NewWriter(w *response.Writer, req *request.Request) (*Writer, error) {
+	hdrs := headers.NewHeaders()
+	hdrs.Set("Content-Type", "text/event-stream")
+	hdrs.Set("Cache-Control", "no-cache")
+	hdrs.Set("Connection", "close")
+	hdrs.Set("Transfer-Encoding", "chunked")
+	hdrs.Set("X-Accel-Buffering", "no")
+
+	err := w.WriteStatusLine(response.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteHeaders(hdrs)
+	if err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("last-event-id")
+	s := &Writer{
+		w:           w,
+		lastEventID: lastEventID,
+		done:        make(chan struct{}),
+	}
+	if conn := w.ClientConn(); conn != nil {
+		go s.watch(conn)
+	}
+	return s, nil
+}
+
+// watch closes the stream once reading conn fails. Clients don't send
+// anything on an event stream, and the connection isn't reused after it, so
+// whatever comes is dropped; the read only ends on EOF, when the client hangs
+// up, or on an error, when the connection is gone.
+func (s *Writer) watch(conn io.Reader) {
+	io.Copy(io.Discard, conn)
+
+	//under the lock, so no write is still going on once Done is closed
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.finish()
+}
+
+func (s *Writer) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the client is gone or the stream is closed. A client
+// hanging up is seen right away over HTTP/1.1; otherwise it is noticed by a
+// failed write, which heartbeats make sure an idle stream gets to.
+func (s *Writer) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Writer) Send(e Event) error {
+	data, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	return s.write(data)
+}
+
+// Comment sends a comment line, which clients ignore.
+func (s *Writer) Comment(text string) error {
+	text = strings.ReplaceAll(text, "\n", " ")
+	return s.write([]byte(": " + text + "\n\n"))
+}
+
+// StartHeartbeat sends a comment every interval until the stream is done, to
+// keep intermediaries from timing out the connection and, where the
+// connection can't be read, to notice clients that went away.
+func (s *Writer) StartHeartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.Comment("heartbeat")
+			}
+		}
+	}()
+}
+
+func (s *Writer) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("event stream is closed")
+	}
+	_, err := s.w.WriteChunkedBody(data)
+	if err != nil {
+		s.closed = true
+		s.finish()
+		return err
+	}
+	return nil
+}
+
+// Close ends the event stream with the last chunk.
+func (s *Writer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.finish()
+
+	_, err := s.w.WriteChunkedbodyDone()
+	if err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
+
+func (s *Writer) finish() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped.
+const subscriberBuffer = 16
+
+// History keeps the most recent events so that reconnecting clients can be
+// sent what they missed since their Last-Event-ID, and hands new events to
+// its subscribers. One producer adds events and every stream subscribes, so
+// ids stay sequential however many clients are connected.
+type History struct {
+	mu          sync.Mutex
+	size        int
+	nextID      int
+	events      []Event
+	subscribers map[chan Event]struct{}
+}
+
+func NewHistory(size int) *History {
+	return &History{size: size, nextID: 1, subscribers: map[chan Event]struct{}{}}
+}
+
+// Add assigns the event the next sequential id and records it.
+func (h *History) Add(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e.ID = strconv.Itoa(h.nextID)
+	h.nextID++
+	h.events = append(h.events, e)
+	if len(h.events) > h.size {
+		h.events = h.events[len(h.events)-h.size:]
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			//a stalled client mustn't hold up the others, it gets the
+			//events back through Last-Event-ID once it reconnects
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// Subscribe returns the recorded events after lastEventID, like Since, and a
+// channel receiving every event added from then on, with nothing lost or sent
+// twice in between. The channel is closed when the subscriber falls too far
+// behind; cancel ends the subscription.
+func (h *History) Subscribe(lastEventID string) ([]Event, <-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	h.subscribers[ch] = struct{}{}
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+	return h.since(lastEventID), ch, cancel
+}
+
+// Since returns the recorded events after lastEventID. An empty or unknown
+// id returns nothing, as there is no way to tell what the client has seen.
+func (h *History) Since(lastEventID string) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.since(lastEventID)
+}
+
+func (h *History) since(lastEventID string) []Event {
+	for i, e := range h.events {
+		if e.ID == lastEventID {
+			return append([]Event{}, h.events[i+1:]...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+// readEvent reads lines up to the blank line that ends an event.
+func readEvent(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	var sb strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			return sb.String()
+		}
+		sb.WriteString(line)
+	}
+}
+
+func TestEventEncode(t *testing.T) {
+	// Test: All fields
+	data, err := Event{ID: "7", Event: "update", Data: "hello", Retry: 3 * time.Second}.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "id: 7\nevent: update\nretry: 3000\ndata: hello\n\n", string(data))
+
+	// Test: Multi-line data
+	data, err = Event{Data: "line one\r\nline two\nline three"}.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "data: line one\ndata: line two\ndata: line three\n\n", string(data))
+
+	// Test: Invalid id and event name
+	_, err = Event{ID: "1\n2"}.Encode()
+	require.Error(t, err)
+	_, err = Event{Event: "a\nb"}.Encode()
+	require.Error(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3)
+	for i := 0; i < 5; i++ {
+		e := h.Add(Event{Data: fmt.Sprintf("event %d", i)})
+		assert.Equal(t, fmt.Sprintf("%d", i+1), e.ID)
+	}
+
+	// Test: Events after a known id
+	missed := h.Since("3")
+	require.Len(t, missed, 2)
+	assert.Equal(t, "event 3", missed[0].Data)
+	assert.Equal(t, "event 4", missed[1].Data)
+
+	// Test: Ids that fell out of the window or were never sent
+	assert.Empty(t, h.Since("1"))
+	assert.Empty(t, h.Since(""))
+	assert.Empty(t, h.Since("5"))
+}
+
+func TestHistorySubscribe(t *testing.T) {
+	h := NewHistory(10)
+	h.Add(Event{Data: "before"})
+
+	// Test: Subscribers get what they missed, then every new event once
+	missed, live, cancel := h.Subscribe("")
+	assert.Empty(t, missed)
+	missed2, live2, cancel2 := h.Subscribe("1")
+	assert.Empty(t, missed2)
+	h.Add(Event{Data: "after"})
+	assert.Equal(t, Event{ID: "2", Data: "after"}, <-live)
+	assert.Equal(t, Event{ID: "2", Data: "after"}, <-live2)
+
+	// Test: Cancelled subscribers get nothing more
+	cancel2()
+	_, ok := <-live2
+	assert.False(t, ok)
+	cancel2()
+
+	// Test: Subscribers that fall behind are dropped instead of blocking
+	for i := 0; i <= subscriberBuffer; i++ {
+		h.Add(Event{Data: fmt.Sprintf("%d", i)})
+	}
+	received := 0
+	for range live {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+	cancel()
+}
+
+func TestWriter(t *testing.T) {
+	history := NewHistory(10)
+	for i := 0; i < 3; i++ {
+		history.Add(Event{Event: "tick", Data: fmt.Sprintf("%d", i)})
+	}
+
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewWriter(w, req)
+		require.NoError(t, err)
+		for _, e := range history.Since(s.LastEventID()) {
+			require.NoError(t, s.Send(e))
+		}
+		require.NoError(t, s.Send(Event{Event: "live", Data: "now"}))
+		require.NoError(t, s.Close())
+		require.Error(t, s.Send(Event{Data: "too late"}))
+	})
+
+	// Test: Reconnecting clients get the events they missed
+	req, err := http.NewRequest("GET", url+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, "id: 2\nevent: tick\ndata: 1\n", readEvent(t, reader))
+	assert.Equal(t, "id: 3\nevent: tick\ndata: 2\n", readEvent(t, reader))
+	assert.Equal(t, "event: live\ndata: now\n", readEvent(t, reader))
+}
+
+func TestHeartbeatAndDisconnect(t *testing.T) {
+	disconnected := make(chan struct{})
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewWriter(w, req)
+		require.NoError(t, err)
+		s.StartHeartbeat(10 * time.Millisecond)
+		select {
+		case <-s.Done():
+			close(disconnected)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	resp, err := http.Get(url + "/events")
+	require.NoError(t, err)
+
+	// Test: Heartbeats are sent as comments
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ": heartbeat\n", line)
+
+	// Test: The writer notices the client going away
+	resp.Body.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client disconnect was not detected")
+	}
+}
+
+func TestDoneOnHangUp(t *testing.T) {
+	disconnected := make(chan struct{})
+	url := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewWriter(w, req)
+		require.NoError(t, err)
+		require.NoError(t, s.Send(Event{Data: "hello"}))
+		select {
+		case <-s.Done():
+			close(disconnected)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	resp, err := http.Get(url + "/events")
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello\n", readEvent(t, bufio.NewReader(resp.Body)))
+
+	// Test: Done closes as soon as the client hangs up, without any writes
+	resp.Body.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("client hang-up was not detected")
+	}
+}
@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+var errTooLarge = errors.New("decompressed message too large")
+
+// deflateTail is the empty stored block a sync flush ends with. RFC 7692
+// strips it from every compressed message and the receiver adds it back.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// compress deflates a whole message. Both sides negotiate no context
+// takeover, so every message is compressed with a fresh window.
+func compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fw, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fw.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = fw.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+func decompress(data []byte, limit int64) ([]byte, error) {
+	//the final empty block stops the reader from expecting more input
+	input := io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail), bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff}))
+	fr := flate.NewReader(input)
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, errTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"www.github.com/isaac-albert/httpfromtcp/internal/headers"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+)
+
+// acceptGUID is appended to Sec-WebSocket-Key before hashing (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+type UpgradeOptions struct {
+	// EnableCompression negotiates permessage-deflate when the client offers it.
+	EnableCompression bool
+	// ReadLimit is the largest message, after decompression, ReadMessage
+	// accepts. Zero means DefaultReadLimit.
+	ReadLimit int64
+}
+
+// IsUpgradeRequest reports whether req asks to switch to the websocket protocol.
+func IsUpgradeRequest(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("upgrade")
+	connection, _ := req.Headers.Get("connection")
+	return headers.HasToken(upgrade, "websocket") && headers.HasToken(connection, "upgrade")
+}
+
+// Upgrade validates the opening handshake, answers it with 101 Switching
+// Protocols and takes over the connection. When the handshake is invalid an
+// error response is written and an error returned.
+func Upgrade(w *response.Writer, req *request.Request, opts *UpgradeOptions) (*Conn, error) {
+	if opts == nil {
+		opts = &UpgradeOptions{}
+	}
+
+	if req.RequestLine.Method != "GET" {
+		writeError(w, response.StatusMethodNotAllowed, nil)
+		return nil, fmt.Errorf("websocket handshake with method '%s'", req.RequestLine.Method)
+	}
+	if !IsUpgradeRequest(req) {
+		writeError(w, response.StatusBadRequest, nil)
+		return nil, fmt.Errorf("request is not a websocket upgrade")
+	}
+	if version, _ := req.Headers.Get("sec-websocket-version"); version != "13" {
+		hdrs := headers.NewHeaders()
+		hdrs.Set("Sec-WebSocket-Version", "13")
+		writeError(w, response.StatusUpgradeRequired, hdrs)
+		return nil, fmt.Errorf("unsupported websocket version '%s'", version)
+	}
+	key, _ := req.Headers.Get("sec-websocket-key")
+	if !validKey(key) {
+		writeError(w, response.StatusBadRequest, nil)
+		return nil, fmt.Errorf("invalid Sec-WebSocket-Key '%s'", key)
+	}
+
+	hdrs := headers.NewHeaders()
+	hdrs.Set("Upgrade", "websocket")
+	hdrs.Set("Connection", "Upgrade")
+	hdrs.Set("Sec-WebSocket-Accept", AcceptKey(key))
+
+	compress := false
+	if opts.EnableCompression {
+		extensions, _ := req.Headers.Get("sec-websocket-extensions")
+		if acceptsDeflate(extensions) {
+			compress = true
+			hdrs.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+		}
+	}
+
+	err := w.WriteStatusLine(response.StatusSwitchingProtocols)
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteHeaders(hdrs)
+	if err != nil {
+		return nil, err
+	}
+
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), netConn))
+	c := newConn(netConn, reader, true, compress)
+	if opts.ReadLimit > 0 {
+		c.ReadLimit = opts.ReadLimit
+	}
+	return c, nil
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func validKey(key string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == 16
+}
+
+// acceptsDeflate reports whether one of the offered extensions is
+// permessage-deflate with parameters we can honour. We always compress with
+// a full 32KiB window, so offers limiting server_max_window_bits are declined.
+func acceptsDeflate(extensions string) bool {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				ok = ok && strings.Trim(value, "\"") == "15"
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w *response.Writer, status response.StatusCode, extra headers.Headers) {
+	body := []byte(fmt.Sprintf("%s\n", response.ReasonPhrase(status)))
+	hdrs := response.GetDefaultHeaders(len(body))
+	for key, val := range extra {
+		hdrs.ForceSet(key, val)
+	}
+	w.WriteStatusLine(status)
+	w.WriteHeaders(hdrs)
+	w.WriteBody(body)
+}
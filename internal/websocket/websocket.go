@@ -0,0 +1,450 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 0x1
+	BinaryMessage MessageType = 0x2
+)
+
+const (
+	opContinuation = 0x0
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	DefaultReadLimit    = 16 << 20
+	maxControlPayload   = 125
+	closeHandshakeLimit = 5 * time.Second
+)
+
+// CloseError is returned by ReadMessage once the peer has closed the
+// connection, or once we closed it because the peer broke the protocol.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// Conn is a websocket connection. ReadMessage must only be called from one
+// goroutine at a time; writes are safe to use concurrently.
+//
+// A Conn closes its network connection itself once the peer closes it, the
+// protocol is broken or reading or writing fails, so a handler that returns
+// on the first error never leaks it. Handlers leaving for other reasons can
+// defer CloseNow.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isServer bool
+	compress bool
+
+	// ReadLimit is the largest message ReadMessage accepts.
+	ReadLimit int64
+	// FragmentSize splits written messages into frames of at most this many
+	// payload bytes. Zero writes every message as a single frame.
+	FragmentSize int
+	// PingHandler and PongHandler are called with the payload of control
+	// frames. By default pings are answered with a pong and pongs ignored.
+	PingHandler func(data []byte) error
+	PongHandler func(data []byte) error
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isServer, compress bool) *Conn {
+	c := &Conn{
+		conn:      conn,
+		reader:    reader,
+		isServer:  isServer,
+		compress:  compress,
+		ReadLimit: DefaultReadLimit,
+	}
+	c.PingHandler = func(data []byte) error {
+		return c.writeFrame(true, false, opPong, data)
+	}
+	c.PongHandler = func([]byte) error { return nil }
+	return c
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  int
+	payload []byte
+}
+
+func (c *Conn) readFrame() (*frame, error) {
+	var head [2]byte
+	_, err := io.ReadFull(c.reader, head[:])
+	if err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    head[0]&0x80 != 0,
+		rsv1:   head[0]&0x40 != 0,
+		opcode: int(head[0] & 0x0F),
+	}
+	if head[0]&0x30 != 0 {
+		return nil, c.protocolError(CloseProtocolError, "reserved bits set")
+	}
+	if f.rsv1 && !c.compress {
+		return nil, c.protocolError(CloseProtocolError, "rsv1 set without compression")
+	}
+
+	masked := head[1]&0x80 != 0
+	if masked != c.isServer {
+		return nil, c.protocolError(CloseProtocolError, "invalid frame masking")
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f.opcode >= opClose {
+		if !f.fin || length > maxControlPayload {
+			return nil, c.protocolError(CloseProtocolError, "invalid control frame")
+		}
+		if f.rsv1 {
+			return nil, c.protocolError(CloseProtocolError, "compressed control frame")
+		}
+	}
+	if length > uint64(c.ReadLimit) {
+		return nil, c.protocolError(CloseMessageTooBig, "frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.reader, mask[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, f.payload)
+	if err != nil {
+		return nil, err
+	}
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+// ReadMessage returns the next complete data message, reassembling fragments
+// and handling control frames that arrive in between. After the peer closes
+// the connection it returns a *CloseError.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		msgType    MessageType
+		message    = []byte{}
+		compressed bool
+		inMessage  bool
+	)
+
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			c.CloseNow()
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case opPing:
+			err = c.PingHandler(f.payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			err = c.PongHandler(f.payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case int(TextMessage), int(BinaryMessage):
+			if inMessage {
+				return 0, nil, c.protocolError(CloseProtocolError, "expected continuation frame")
+			}
+			inMessage = true
+			msgType = MessageType(f.opcode)
+			compressed = f.rsv1
+		case opContinuation:
+			if !inMessage {
+				return 0, nil, c.protocolError(CloseProtocolError, "unexpected continuation frame")
+			}
+			if f.rsv1 {
+				return 0, nil, c.protocolError(CloseProtocolError, "rsv1 set on continuation frame")
+			}
+		default:
+			return 0, nil, c.protocolError(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode))
+		}
+
+		if int64(len(message)+len(f.payload)) > c.ReadLimit {
+			return 0, nil, c.protocolError(CloseMessageTooBig, "message too large")
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = decompress(message, c.ReadLimit)
+			if errors.Is(err, errTooLarge) {
+				return 0, nil, c.protocolError(CloseMessageTooBig, "message too large")
+			}
+			if err != nil {
+				return 0, nil, c.protocolError(CloseInvalidPayload, "invalid compressed data")
+			}
+		}
+		if msgType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.protocolError(CloseInvalidPayload, "invalid utf-8 in text message")
+		}
+		return msgType, message, nil
+	}
+}
+
+// WriteMessage sends data as a single message, compressed when
+// permessage-deflate was negotiated and split into frames of FragmentSize.
+func (c *Conn) WriteMessage(msgType MessageType, data []byte) error {
+	if msgType != TextMessage && msgType != BinaryMessage {
+		return fmt.Errorf("invalid message type %d", msgType)
+	}
+
+	compressed := false
+	if c.compress {
+		var err error
+		data, err = compress(data)
+		if err != nil {
+			return err
+		}
+		compressed = true
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	opcode := int(msgType)
+	for {
+		chunk := data
+		if c.FragmentSize > 0 && len(chunk) > c.FragmentSize {
+			chunk = data[:c.FragmentSize]
+		}
+		data = data[len(chunk):]
+		fin := len(data) == 0
+
+		err := c.writeFrameLocked(fin, compressed, opcode, chunk)
+		if err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		opcode = opContinuation
+		compressed = false
+	}
+}
+
+func (c *Conn) Ping(data []byte) error {
+	return c.writeFrame(true, false, opPing, data)
+}
+
+func (c *Conn) writeFrame(fin, rsv1 bool, opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(fin, rsv1, opcode, payload)
+}
+
+func (c *Conn) writeFrameLocked(fin, rsv1 bool, opcode int, payload []byte) error {
+	if c.closeSent {
+		return fmt.Errorf("websocket close frame already sent")
+	}
+	if opcode >= opClose && len(payload) > maxControlPayload {
+		return fmt.Errorf("control frame payload too large")
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	buf = append(buf, b0)
+
+	var b1 byte
+	if !c.isServer {
+		b1 = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		buf = append(buf, b1|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		buf = append(buf, b1|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, b1|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+
+	start := len(buf)
+	if !c.isServer {
+		var mask [4]byte
+		_, err := rand.Read(mask[:])
+		if err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start = len(buf)
+		buf = append(buf, payload...)
+		maskBytes(mask, buf[start:])
+	} else {
+		buf = append(buf, payload...)
+	}
+
+	_, err := c.conn.Write(buf)
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	if err != nil {
+		c.CloseNow()
+	}
+	return err
+}
+
+// Close starts the closing handshake: it sends a close frame, waits for the
+// peer to answer with its own and closes the connection.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.writeFrame(true, false, opClose, closePayload(code, reason))
+	if err != nil {
+		c.CloseNow()
+		return err
+	}
+
+	//drain until the peer's close frame arrives or it gives up
+	c.conn.SetReadDeadline(time.Now().Add(closeHandshakeLimit))
+	for {
+		f, err := c.readFrame()
+		if err != nil || f.opcode == opClose {
+			break
+		}
+	}
+	return c.CloseNow()
+}
+
+// CloseNow closes the network connection without a closing handshake. It is
+// safe to call more than once and after the Conn closed itself.
+func (c *Conn) CloseNow() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.protocolError(CloseProtocolError, "invalid close payload")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.protocolError(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.protocolError(CloseInvalidPayload, "invalid utf-8 in close reason")
+		}
+	}
+
+	//echo the status code back to complete the handshake
+	reply := []byte{}
+	if closeErr.Code != CloseNoStatus {
+		reply = closePayload(closeErr.Code, "")
+	}
+	c.writeFrame(true, false, opClose, reply)
+	c.CloseNow()
+	return closeErr
+}
+
+// protocolError fails the connection, telling the peer why before closing it.
+func (c *Conn) protocolError(code int, reason string) error {
+	c.writeFrame(true, false, opClose, closePayload(code, reason))
+	c.CloseNow()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func closePayload(code int, reason string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+func maskBytes(mask [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
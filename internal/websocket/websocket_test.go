@@ -0,0 +1,385 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.github.com/isaac-albert/httpfromtcp/internal/request"
+	"www.github.com/isaac-albert/httpfromtcp/internal/response"
+	"www.github.com/isaac-albert/httpfromtcp/internal/servertest"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// startEchoServer upgrades every request and echoes messages back. The error
+// that ended each connection is sent on the returned channel.
+func startEchoServer(t *testing.T, opts *UpgradeOptions, configure func(*Conn)) (string, chan error) {
+	t.Helper()
+	done := make(chan error, 10)
+	base := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		c, err := Upgrade(w, req, opts)
+		if err != nil {
+			done <- err
+			return
+		}
+		if configure != nil {
+			configure(c)
+		}
+		for {
+			msgType, data, err := c.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			err = c.WriteMessage(msgType, data)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	})
+	return strings.TrimPrefix(base, "http://"), done
+}
+
+// handshake sends an opening handshake with the given extra header lines and
+// returns the raw connection, a reader positioned after the response head, the
+// status line and the response headers.
+func handshake(t *testing.T, addr string, extra ...string) (net.Conn, *bufio.Reader, string, map[string]string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	lines := []string{
+		"GET /ws HTTP/1.1",
+		"Host: " + addr,
+	}
+	lines = append(lines, extra...)
+	_, err = conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	hdrs := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		key, val, _ := strings.Cut(strings.TrimSpace(line), ":")
+		hdrs[strings.ToLower(key)] = strings.TrimSpace(val)
+	}
+	return conn, reader, status, hdrs
+}
+
+func dial(t *testing.T, addr string, extra ...string) (*Conn, map[string]string) {
+	t.Helper()
+	lines := append([]string{
+		"Upgrade: websocket",
+		"Connection: keep-alive, Upgrade",
+		"Sec-WebSocket-Version: 13",
+		"Sec-WebSocket-Key: " + testKey,
+	}, extra...)
+	conn, reader, status, hdrs := handshake(t, addr, lines...)
+	require.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	compress := strings.Contains(hdrs["sec-websocket-extensions"], "permessage-deflate")
+	return newConn(conn, reader, false, compress), hdrs
+}
+
+func waitErr(t *testing.T, done chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not finish")
+		return nil
+	}
+}
+
+func closeCode(err error) int {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code
+	}
+	return 0
+}
+
+func TestHandshake(t *testing.T) {
+	addr, done := startEchoServer(t, nil, nil)
+
+	// Test: Sec-WebSocket-Accept from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey(testKey))
+
+	c, hdrs := dial(t, addr)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", hdrs["sec-websocket-accept"])
+	assert.Equal(t, "websocket", hdrs["upgrade"])
+	assert.Empty(t, hdrs["sec-websocket-extensions"])
+	c.Close(CloseNormal, "")
+	waitErr(t, done)
+
+	// Test: Missing key
+	_, _, status, _ := handshake(t, addr, "Upgrade: websocket", "Connection: Upgrade", "Sec-WebSocket-Version: 13")
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", status)
+	require.Error(t, waitErr(t, done))
+
+	// Test: Key that is not 16 base64 encoded bytes
+	_, _, status, _ = handshake(t, addr, "Upgrade: websocket", "Connection: Upgrade", "Sec-WebSocket-Version: 13", "Sec-WebSocket-Key: c2hvcnQ=")
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", status)
+	require.Error(t, waitErr(t, done))
+
+	// Test: Unsupported version advertises the supported one
+	_, _, status, hdrs = handshake(t, addr, "Upgrade: websocket", "Connection: Upgrade", "Sec-WebSocket-Version: 8", "Sec-WebSocket-Key: "+testKey)
+	assert.Equal(t, "HTTP/1.1 426 Upgrade Required\r\n", status)
+	assert.Equal(t, "13", hdrs["sec-websocket-version"])
+	require.Error(t, waitErr(t, done))
+
+	// Test: Not an upgrade request
+	_, _, status, _ = handshake(t, addr)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", status)
+	require.Error(t, waitErr(t, done))
+}
+
+func TestEcho(t *testing.T) {
+	addr, done := startEchoServer(t, nil, nil)
+	c, _ := dial(t, addr)
+
+	// Test: Payloads using the 7 bit, 16 bit and 64 bit length encodings
+	for _, size := range []int{0, 125, 126, 65535, 65536, 200000} {
+		payload := bytes.Repeat([]byte("x"), size)
+		require.NoError(t, c.WriteMessage(BinaryMessage, payload))
+		msgType, data, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, BinaryMessage, msgType)
+		assert.Equal(t, payload, data)
+	}
+
+	// Test: Text messages
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("héllo wörld")))
+	msgType, data, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, msgType)
+	assert.Equal(t, "héllo wörld", string(data))
+
+	// Test: Close handshake initiated by the client
+	require.NoError(t, c.Close(CloseNormal, "bye"))
+	err = waitErr(t, done)
+	assert.Equal(t, CloseNormal, closeCode(err))
+	assert.Equal(t, "bye", err.(*CloseError).Reason)
+}
+
+func TestFragmentation(t *testing.T) {
+	addr, done := startEchoServer(t, nil, func(c *Conn) {
+		c.FragmentSize = 4
+	})
+	c, _ := dial(t, addr)
+
+	pongs := make(chan string, 1)
+	c.PongHandler = func(data []byte) error {
+		pongs <- string(data)
+		return nil
+	}
+
+	// Test: Fragments with a ping in between are reassembled
+	require.NoError(t, c.writeFrame(false, false, int(TextMessage), []byte("frag")))
+	require.NoError(t, c.Ping([]byte("are you there")))
+	require.NoError(t, c.writeFrame(false, false, opContinuation, []byte("mented ")))
+	require.NoError(t, c.writeFrame(true, false, opContinuation, []byte("message")))
+
+	// Test: The server answers with fragments of FragmentSize
+	f, err := c.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, opPong, f.opcode)
+	assert.Equal(t, "are you there", string(f.payload))
+
+	f, err = c.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, int(TextMessage), f.opcode)
+	assert.False(t, f.fin)
+	assert.Equal(t, "frag", string(f.payload))
+
+	f, err = c.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, opContinuation, f.opcode)
+
+	msg := string(f.payload)
+	for !f.fin {
+		f, err = c.readFrame()
+		require.NoError(t, err)
+		assert.Equal(t, opContinuation, f.opcode)
+		assert.LessOrEqual(t, len(f.payload), 4)
+		msg += string(f.payload)
+	}
+	assert.Equal(t, "mented message", msg)
+
+	// Test: Pongs are passed to the pong handler
+	require.NoError(t, c.Ping([]byte("again")))
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("x")))
+	_, _, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "again", <-pongs)
+
+	c.Close(CloseNormal, "")
+	waitErr(t, done)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	addr, done := startEchoServer(t, &UpgradeOptions{ReadLimit: 1024}, nil)
+
+	cases := []struct {
+		name string
+		send func(c *Conn) error
+		code int
+		raw  []byte
+	}{
+		{
+			name: "unmasked client frame",
+			raw:  []byte{0x81, 0x02, 'h', 'i'},
+			code: CloseProtocolError,
+		},
+		{
+			name: "continuation without a message",
+			send: func(c *Conn) error { return c.writeFrame(true, false, opContinuation, []byte("x")) },
+			code: CloseProtocolError,
+		},
+		{
+			name: "new message inside a fragmented one",
+			send: func(c *Conn) error {
+				c.writeFrame(false, false, int(TextMessage), []byte("a"))
+				return c.writeFrame(true, false, int(TextMessage), []byte("b"))
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name: "fragmented control frame",
+			send: func(c *Conn) error { return c.writeFrame(false, false, opPing, []byte("x")) },
+			code: CloseProtocolError,
+		},
+		{
+			name: "reserved opcode",
+			send: func(c *Conn) error { return c.writeFrame(true, false, 0x3, []byte("x")) },
+			code: CloseProtocolError,
+		},
+		{
+			name: "compressed frame without negotiation",
+			send: func(c *Conn) error { return c.writeFrame(true, true, int(TextMessage), []byte("x")) },
+			code: CloseProtocolError,
+		},
+		{
+			name: "invalid utf-8",
+			send: func(c *Conn) error { return c.WriteMessage(TextMessage, []byte{0xff, 0xfe}) },
+			code: CloseInvalidPayload,
+		},
+		{
+			name: "message over the read limit",
+			send: func(c *Conn) error { return c.WriteMessage(BinaryMessage, make([]byte, 2048)) },
+			code: CloseMessageTooBig,
+		},
+		{
+			name: "invalid close code",
+			send: func(c *Conn) error { return c.writeFrame(true, false, opClose, closePayload(1005, "")) },
+			code: CloseProtocolError,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := dial(t, addr)
+			if tc.raw != nil {
+				_, err := c.conn.Write(tc.raw)
+				require.NoError(t, err)
+			} else {
+				require.NoError(t, tc.send(c))
+			}
+
+			assert.Equal(t, tc.code, closeCode(waitErr(t, done)))
+
+			// the server tells us why before closing
+			f, err := c.readFrame()
+			require.NoError(t, err)
+			assert.Equal(t, opClose, f.opcode)
+			require.GreaterOrEqual(t, len(f.payload), 2)
+			assert.Equal(t, tc.code, int(f.payload[0])<<8|int(f.payload[1]))
+		})
+	}
+}
+
+func TestPerMessageDeflate(t *testing.T) {
+	addr, done := startEchoServer(t, &UpgradeOptions{EnableCompression: true}, nil)
+
+	// Test: Offers limiting the server window are declined
+	c, hdrs := dial(t, addr, "Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10")
+	assert.Empty(t, hdrs["sec-websocket-extensions"])
+	c.Close(CloseNormal, "")
+	waitErr(t, done)
+
+	c, hdrs = dial(t, addr, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits")
+	assert.Contains(t, hdrs["sec-websocket-extensions"], "permessage-deflate")
+
+	// Test: Compressed messages round trip and are sent with rsv1 set
+	payload := []byte(strings.Repeat("compress me please ", 500))
+	require.NoError(t, c.WriteMessage(TextMessage, payload))
+	f, err := c.readFrame()
+	require.NoError(t, err)
+	assert.True(t, f.rsv1)
+	assert.Less(t, len(f.payload), len(payload))
+	data, err := decompress(f.payload, DefaultReadLimit)
+	require.NoError(t, err)
+	assert.Equal(t, payload, data)
+
+	// Test: Compressed and fragmented
+	c.FragmentSize = 16
+	require.NoError(t, c.WriteMessage(BinaryMessage, payload))
+	msgType, data, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, msgType)
+	assert.Equal(t, payload, data)
+
+	c.Close(CloseNormal, "")
+	assert.Equal(t, CloseNormal, closeCode(waitErr(t, done)))
+}
+
+func TestDroppedConnection(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	addr, done := startEchoServer(t, nil, func(c *Conn) { conns <- c })
+	client, _ := dial(t, addr)
+	serverConn := <-conns
+
+	// Test: A peer going away without a close frame ends ReadMessage with
+	// an I/O error and the connection is closed on our side
+	client.conn.Close()
+	err := waitErr(t, done)
+	assert.Error(t, err)
+	assert.Zero(t, closeCode(err))
+	_, err = serverConn.conn.Write([]byte{0})
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.NoError(t, serverConn.CloseNow())
+}
+
+func TestServerInitiatedClose(t *testing.T) {
+	closed := make(chan error, 1)
+	base := servertest.Start(t, func(w *response.Writer, req *request.Request) {
+		c, err := Upgrade(w, req, nil)
+		if err != nil {
+			closed <- err
+			return
+		}
+		closed <- c.Close(CloseGoingAway, "shutting down")
+	})
+
+	c, _ := dial(t, strings.TrimPrefix(base, "http://"))
+	_, _, err := c.ReadMessage()
+	assert.Equal(t, CloseGoingAway, closeCode(err))
+	assert.Equal(t, "shutting down", err.(*CloseError).Reason)
+	require.NoError(t, <-closed)
+}